@@ -27,7 +27,11 @@ def load_data(filename, drop_control=True, risk_means=None, D=1):
 
     with open(DATA_LOCATION + filename) as file:
             json_file = json.load(file, parse_float=lambda f: round(float(f), 3))
-            
+
+    # Sweeps embed the experiment that produced them alongside the results.
+    if isinstance(json_file, dict):
+        json_file = json_file["Series"]
+
     data = process(pd.json_normalize(
         json_file,
         record_path=["RunSets", "Runs"],
@@ -43,6 +47,37 @@ def load_data(filename, drop_control=True, risk_means=None, D=1):
     return data
 
 
+def load_metadata(filename):
+    """Returns the metadata a run, sweep or table was written with."""
+
+    path = DATA_LOCATION + filename
+    if filename.endswith(".parquet"):
+        import pyarrow.parquet as pq
+        footer = pq.read_metadata(path).metadata
+        return json.loads(footer[b"Metadata"])
+    with open(path) as file:
+        if filename.endswith(".json"):
+            return json.load(file)["Metadata"]
+        return json.loads(file.readline().lstrip("# "))["Metadata"]
+
+
+def load_table(filename, drop_control=True, risk_means=None, D=1):
+    """Loads the flat output of `hotspot sweep -format csv|jsonl|parquet`."""
+
+    path = DATA_LOCATION + filename
+    # CSV and JSON Lines files start with a line of metadata.
+    if filename.endswith(".csv"):
+        data = pd.read_csv(path, comment="#")
+    elif filename.endswith(".jsonl"):
+        data = pd.read_json(path, lines=True).iloc[1:].reset_index(drop=True)
+    elif filename.endswith(".parquet"):
+        data = pd.read_parquet(path)
+    else:
+        raise ValueError("unknown file type: " + filename)
+
+    return process(data, drop_control, risk_means, D)
+
+
 # Process data
 def process(data, drop_control, risk_means, D):
 
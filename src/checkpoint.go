@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+// A checkpoint file holds the finished points of a sweep, so that an
+// interrupted sweep can pick up where it left off. It is a JSON Lines file:
+// the first line holds the experiment, and every other line a finished
+// RunSet keyed by its parameters.
+type checkpoint struct {
+	fileName string
+	// The experiment the checkpoint was made for, or nil for a new file.
+	experiment *simulate.Experiment
+	done       map[string]simulate.RunSet
+	// Length of the file up to the last complete line; anything after it was
+	// cut off by a crash.
+	validLength int64
+	file        *os.File
+}
+
+type checkpointEntry struct {
+	Experiment *simulate.Experiment `json:",omitempty"`
+	Key        string               `json:",omitempty"`
+	RunSet     *simulate.RunSet     `json:",omitempty"`
+}
+
+// Identifies a point by everything that affects its results, including its
+// seed.
+func checkpointKey(param simulate.Parameters) string {
+	data, err := json.Marshal(param)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Reads the checkpoint in fileName, if there is one.
+func loadCheckpoint(fileName string) (*checkpoint, error) {
+	c := &checkpoint{fileName: fileName, done: map[string]simulate.RunSet{}}
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// An unterminated last line was being written when the sweep
+			// stopped.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry checkpointEntry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		switch {
+		case c.experiment == nil && entry.Experiment != nil:
+			c.experiment = entry.Experiment
+		case c.experiment != nil && entry.RunSet != nil:
+			c.done[entry.Key] = *entry.RunSet
+		default:
+			return nil, fmt.Errorf("%s: unexpected checkpoint entry", fileName)
+		}
+		c.validLength += int64(len(line))
+	}
+	return c, nil
+}
+
+// Adopts the seed of the checkpointed experiment if the experiment doesn't
+// have one yet, so that resuming an unseeded sweep continues the same
+// random streams.
+func (c *checkpoint) resume(experiment *simulate.Experiment) error {
+	if c.experiment == nil {
+		return nil
+	}
+	if experiment.Seed == 0 {
+		experiment.Seed = c.experiment.Seed
+	}
+	if experiment.Seed != c.experiment.Seed {
+		return fmt.Errorf("checkpoint %s was made with seed %d, not %d",
+			c.fileName, c.experiment.Seed, experiment.Seed)
+	}
+	return nil
+}
+
+// Opens the checkpoint for appending, dropping any partly written line, and
+// starts a new file with the experiment if there wasn't one.
+func (c *checkpoint) open(experiment simulate.Experiment) error {
+	file, err := os.OpenFile(c.fileName, os.O_CREATE|os.O_WRONLY, DATA_FILE_PERMISSIONS)
+	if err != nil {
+		return err
+	}
+	c.file = file
+	if err := file.Truncate(c.validLength); err != nil {
+		return err
+	}
+	if _, err := file.Seek(c.validLength, io.SeekStart); err != nil {
+		return err
+	}
+	if c.experiment == nil {
+		c.experiment = &experiment
+		return c.append(checkpointEntry{Experiment: &experiment})
+	}
+	return nil
+}
+
+func (c *checkpoint) lookup(param simulate.Parameters) (simulate.RunSet, bool) {
+	runSet, ok := c.done[checkpointKey(param)]
+	return runSet, ok
+}
+
+func (c *checkpoint) save(runSet simulate.RunSet) error {
+	return c.append(checkpointEntry{Key: checkpointKey(runSet.Parameters), RunSet: &runSet})
+}
+
+// Writes an entry and syncs it to disk.
+func (c *checkpoint) append(entry checkpointEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	experiment := simulate.Experiment{
+		RunType:         simulate.RunTypes{simulate.Simulation},
+		N:               simulate.Axis{Values: []float64{200}},
+		Trials:          simulate.Axis{Values: []float64{10}},
+		DiseaseLength:   simulate.Axis{Values: []float64{1}},
+		HotspotFraction: simulate.Axis{Values: []float64{0, 0.5}},
+		RiskMean:        simulate.Axis{Values: []float64{0.25}},
+		RiskVariance:    simulate.RiskVariances{simulate.MediumVar},
+		R0:              simulate.Axis{Range: &simulate.Range{Start: 0, End: 3, Step: 1}},
+	}
+	config := runConfig{Workers: 2, TrialWorkers: 1}
+	fileName := filepath.Join(t.TempDir(), "sweep.checkpoint")
+
+	// Run the first series, then stop part way through writing the next
+	// point.
+	cp, err := loadCheckpoint(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	experiment.Seed = 11
+	if err := cp.open(experiment); err != nil {
+		t.Fatal(err)
+	}
+	allSeries, err := experiment.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runExperiment(allSeries[:1], config, &memorySink{}, cp); err != nil {
+		t.Fatal(err)
+	}
+	cp.file.WriteString(`{"Key":"abc","RunSet":{"Par`)
+	cp.Close()
+
+	// Resuming without a seed picks up the checkpoint's.
+	experiment.Seed = 0
+	cp, err = loadCheckpoint(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.done) != 4 {
+		t.Fatalf("checkpoint holds %v points; want 4", len(cp.done))
+	}
+	if err := cp.resume(&experiment); err != nil {
+		t.Fatal(err)
+	}
+	if experiment.Seed != 11 {
+		t.Fatalf("resumed with seed %v; want 11", experiment.Seed)
+	}
+	if err := cp.open(experiment); err != nil {
+		t.Fatal(err)
+	}
+	got := &memorySink{}
+	if err := runExperiment(allSeries, config, got, cp); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	want := &memorySink{}
+	if err := runExperiment(allSeries, config, want, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(want.runSets) != 8 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("resumed sweep differs from an uninterrupted one")
+	}
+
+	// Every point is now in the checkpoint, and the partial line is gone.
+	cp, err = loadCheckpoint(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(fileName); len(cp.done) != 8 || info.Size() != cp.validLength {
+		t.Fatalf("checkpoint holds %v points and %v bytes after the last line", len(cp.done), info.Size()-cp.validLength)
+	}
+
+	experiment.Seed = 12
+	if err := cp.resume(&experiment); err == nil {
+		t.Fatalf("resumed a checkpoint with a different seed")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+// The result of running the job at index.
+type result struct {
+	index  int
+	runSet simulate.RunSet
+	err    error
+}
+
+// Runs every job on a pool of workers and passes the results to emit in the
+// order of the jobs: a result is held back until all earlier ones have been
+// emitted, so the output is the same no matter how many workers there are.
+// Once a job fails no more are started: the running ones finish, and the
+// results are emitted up to the first failure, along with its error.
+func execute(jobs []simulate.Parameters, workers int,
+	run func(simulate.Parameters) (simulate.RunSet, error), emit func(int, simulate.RunSet, error)) {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	indices := make(chan int)
+	results := make(chan result)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				runSet, err := run(jobs[i])
+				results <- result{index: i, runSet: runSet, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(indices)
+		for i := range jobs {
+			select {
+			case indices <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Jobs are started in order, so every job before a failed one has been
+	// started and is emitted. Nothing after the first failure is.
+	pending := map[int]result{}
+	next, failed, stopped := 0, false, false
+	for r := range results {
+		if r.err != nil && !failed {
+			failed = true
+			close(done)
+		}
+		if stopped {
+			continue
+		}
+		pending[r.index] = r
+		for r, ok := pending[next]; ok && !stopped; r, ok = pending[next] {
+			delete(pending, next)
+			emit(next, r.runSet, r.err)
+			next++
+			stopped = r.err != nil
+		}
+	}
+}
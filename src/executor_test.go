@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+func TestExecuteOrder(t *testing.T) {
+	jobs := []simulate.Parameters{}
+	for i := 0; i < 50; i++ {
+		jobs = append(jobs, simulate.Parameters{R0: float64(i)})
+	}
+	// Later jobs finish first, so results arrive out of order.
+	run := func(param simulate.Parameters) (simulate.RunSet, error) {
+		time.Sleep(time.Duration(50-param.R0) * 10 * time.Microsecond)
+		return simulate.RunSet{Parameters: param}, nil
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		emitted := []int{}
+		execute(jobs, workers, run, func(i int, runSet simulate.RunSet, err error) {
+			if runSet.Parameters.R0 != float64(i) {
+				t.Fatalf("workers=%v: result %v emitted at index %v", workers, runSet.Parameters.R0, i)
+			}
+			emitted = append(emitted, i)
+		})
+		if len(emitted) != len(jobs) {
+			t.Fatalf("workers=%v: emitted %v results; want %v", workers, len(emitted), len(jobs))
+		}
+		for i, index := range emitted {
+			if index != i {
+				t.Fatalf("workers=%v: emitted %v in position %v", workers, index, i)
+			}
+		}
+	}
+}
+
+// The jobs after a failed one aren't run, and nothing after it is emitted.
+func TestExecuteError(t *testing.T) {
+	jobs := []simulate.Parameters{}
+	for i := 0; i < 50; i++ {
+		jobs = append(jobs, simulate.Parameters{R0: float64(i)})
+	}
+	var ran int32
+	run := func(param simulate.Parameters) (simulate.RunSet, error) {
+		atomic.AddInt32(&ran, 1)
+		if param.R0 == 1 {
+			return simulate.RunSet{}, errors.New("failed")
+		}
+		time.Sleep(time.Millisecond)
+		return simulate.RunSet{Parameters: param}, nil
+	}
+	emitted, failed := []int{}, []int{}
+	execute(jobs, 2, run, func(i int, runSet simulate.RunSet, err error) {
+		emitted = append(emitted, i)
+		if err != nil {
+			failed = append(failed, i)
+		}
+	})
+	if !reflect.DeepEqual(emitted, []int{0, 1}) || !reflect.DeepEqual(failed, []int{1}) {
+		t.Fatalf("emitted %v with %v failed; want [0 1] with [1]", emitted, failed)
+	}
+	if ran > 5 {
+		t.Fatalf("ran %d jobs after the second failed", ran)
+	}
+}
+
+func TestRunExperimentDeterministic(t *testing.T) {
+	experiment := simulate.Experiment{
+		Seed:            7,
+		RunType:         simulate.RunTypes{simulate.Simulation},
+		N:               simulate.Axis{Values: []float64{200}},
+		Trials:          simulate.Axis{Values: []float64{10}},
+		DiseaseLength:   simulate.Axis{Values: []float64{1}},
+		HotspotFraction: simulate.Axis{Values: []float64{0, 0.5}},
+		RiskMean:        simulate.Axis{Values: []float64{0.25}},
+		RiskVariance:    simulate.RiskVariances{simulate.MediumVar},
+		R0:              simulate.Axis{Range: &simulate.Range{Start: 0, End: 3, Step: 1}},
+	}
+
+	allSeries, err := experiment.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, got := &memorySink{}, &memorySink{}
+	if err := runExperiment(allSeries, runConfig{Workers: 1, TrialWorkers: 1}, want, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := runExperiment(allSeries, runConfig{Workers: 4, TrialWorkers: 2}, got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(want.runSets) != 8 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("parallel and sequential runs of the same experiment differ")
+	}
+}
+
+// Keeps every RunSet it is given.
+type memorySink struct {
+	runSets []simulate.RunSet
+}
+
+func (s *memorySink) Write(runSet simulate.RunSet) error {
+	s.runSets = append(s.runSets, runSet)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
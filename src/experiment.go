@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/brendanwallace/hotspot/simulate"
+	"gopkg.in/yaml.v3"
+)
+
+// The experiment to sweep over: either the one in the -experiment file, with
+// any axes it leaves out taken from the flags, or the one the axis flags
+// describe. A Parameters block in the file replaces the flags' settings
+// entirely. The seed is left at zero if neither gives one.
+func (c sweepConfig) experiment() (simulate.Experiment, error) {
+	base := c.base()
+	fromFlags := simulate.Experiment{
+		Seed:            c.Seed,
+		Parameters:      &base,
+		RunType:         simulate.RunTypes{c.runType()},
+		N:               simulate.Axis{Values: []float64{float64(c.N)}},
+		Trials:          simulate.Axis{Values: []float64{float64(c.Trials)}},
+		DiseaseLength:   simulate.Axis{Values: []float64{float64(c.DiseasePeriod)}},
+		HotspotFraction: simulate.Axis{Values: c.HotspotFractions},
+		RiskMean:        simulate.Axis{Values: c.RiskMeans},
+		RiskVariance:    simulate.RiskVariances(c.RiskVariances),
+		R0:              simulate.Axis{Range: &simulate.Range{Start: 0, End: c.EndR0, Step: c.R0Step}},
+	}
+	if c.Experiment == "" {
+		return fromFlags, nil
+	}
+
+	experiment, err := loadExperiment(c.Experiment)
+	if err != nil {
+		return simulate.Experiment{}, err
+	}
+	if experiment.Parameters == nil {
+		experiment.Parameters = fromFlags.Parameters
+	}
+	if experiment.Seed == 0 {
+		experiment.Seed = fromFlags.Seed
+	}
+	if len(experiment.RunType) == 0 {
+		experiment.RunType = fromFlags.RunType
+	}
+	if experiment.N.IsEmpty() {
+		experiment.N = fromFlags.N
+	}
+	if experiment.Trials.IsEmpty() {
+		experiment.Trials = fromFlags.Trials
+	}
+	if experiment.DiseaseLength.IsEmpty() {
+		experiment.DiseaseLength = fromFlags.DiseaseLength
+	}
+	if experiment.HotspotFraction.IsEmpty() {
+		experiment.HotspotFraction = fromFlags.HotspotFraction
+	}
+	if experiment.RiskMean.IsEmpty() {
+		experiment.RiskMean = fromFlags.RiskMean
+	}
+	if len(experiment.RiskVariance) == 0 {
+		experiment.RiskVariance = fromFlags.RiskVariance
+	}
+	if experiment.R0.IsEmpty() {
+		experiment.R0 = fromFlags.R0
+	}
+	return experiment, experiment.Validate()
+}
+
+// Reads an experiment spec, picking the format from the file extension. The
+// output of a sweep can be read back in as well, in which case the
+// experiment embedded in it is used.
+func loadExperiment(fileName string) (simulate.Experiment, error) {
+	var experiment simulate.Experiment
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return experiment, err
+	}
+
+	// YAML and TOML are decoded generically and converted to JSON, so that
+	// the JSON decoding rules of simulate.Experiment apply to every format.
+	var spec map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(fileName)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &spec)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &spec)
+	case ".toml":
+		err = toml.Unmarshal(data, &spec)
+	default:
+		return experiment, fmt.Errorf("%s: unknown experiment file extension %q", fileName, ext)
+	}
+	if err != nil {
+		return experiment, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if embedded, ok := spec["Experiment"]; ok {
+		if embedded, ok := embedded.(map[string]interface{}); ok {
+			spec = embedded
+		}
+	}
+
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return experiment, fmt.Errorf("%s: %v", fileName, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(specJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&experiment); err != nil {
+		return experiment, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return experiment, nil
+}
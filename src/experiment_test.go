@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+func TestLoadExperiment(t *testing.T) {
+	want := simulate.Experiment{
+		Name:            "example",
+		RunType:         simulate.RunTypes{simulate.DifEq},
+		HotspotFraction: simulate.Axis{Values: []float64{0, 0.5}},
+		RiskMean:        simulate.Axis{Values: []float64{0.25}},
+		RiskVariance:    simulate.RiskVariances{simulate.LowVar, simulate.HighVar},
+		R0:              simulate.Axis{Range: &simulate.Range{Start: 0, End: 5, Step: 0.1}},
+	}
+
+	dir := t.TempDir()
+	for name, spec := range map[string]string{
+		"example.json": `{
+			"Name": "example",
+			"RunType": "difeq",
+			"HotspotFraction": [0, 0.5],
+			"RiskMean": 0.25,
+			"RiskVariance": ["low", "high"],
+			"R0": {"Range": {"Start": 0, "End": 5, "Step": 0.1}}
+		}`,
+		"example.yaml": `
+Name: example
+RunType: difeq
+HotspotFraction: [0, 0.5]
+RiskMean: 0.25
+RiskVariance: [low, high]
+R0:
+  Range: {Start: 0, End: 5, Step: 0.1}
+`,
+		"example.toml": `
+Name = "example"
+RunType = "difeq"
+HotspotFraction = [0, 0.5]
+RiskMean = 0.25
+RiskVariance = ["low", "high"]
+R0 = { Range = { Start = 0, End = 5, Step = 0.1 } }
+`,
+		// The output of a sweep embeds the experiment that produced it.
+		"results.json": `{
+			"Experiment": {
+				"Name": "example",
+				"RunType": ["difeq"],
+				"HotspotFraction": {"Values": [0, 0.5]},
+				"RiskMean": {"Values": [0.25]},
+				"RiskVariance": ["low", "high"],
+				"R0": {"Range": {"Start": 0, "End": 5, "Step": 0.1}}
+			},
+			"Series": []
+		}`,
+	} {
+		fileName := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fileName, []byte(spec), DATA_FILE_PERMISSIONS); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadExperiment(fileName)
+		if err != nil {
+			t.Fatalf("loadExperiment(%s): %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("loadExperiment(%s) = %+v; want %+v", name, got, want)
+		}
+	}
+
+	fileName := filepath.Join(dir, "typo.json")
+	if err := ioutil.WriteFile(fileName, []byte(`{"R0s": [1, 2]}`), DATA_FILE_PERMISSIONS); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadExperiment(fileName); err == nil {
+		t.Fatalf("expected an error for an unknown field")
+	}
+}
+
+// Axes left out of the experiment file are taken from the flags.
+func TestExperimentFlagDefaults(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "partial.yaml")
+	if err := ioutil.WriteFile(fileName, []byte("RunType: difeq\nN: 500\n"), DATA_FILE_PERMISSIONS); err != nil {
+		t.Fatal(err)
+	}
+	var c sweepConfig
+	fs := flag.NewFlagSet("sweep", flag.ContinueOnError)
+	c.register(fs)
+	if err := fs.Parse([]string{"-experiment", fileName, "-hotspot-fractions", "0.5", "-risk-means", "0.3",
+		"-risk-variances", "high", "-r0-end", "2", "-r0-step", "0.5"}); err != nil {
+		t.Fatal(err)
+	}
+	experiment, err := c.experiment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(experiment.N.Values, []float64{500}) ||
+		!reflect.DeepEqual(experiment.HotspotFraction.Values, []float64{0.5}) ||
+		!reflect.DeepEqual(experiment.RiskMean.Values, []float64{0.3}) ||
+		!reflect.DeepEqual(experiment.RiskVariance, simulate.RiskVariances{simulate.HighVar}) ||
+		!reflect.DeepEqual(experiment.R0.Range, &simulate.Range{Start: 0, End: 2, Step: 0.5}) {
+		t.Fatalf("experiment %+v doesn't take its missing axes from the flags", experiment)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+// Comma separated list of floats, e.g. "0.0,0.25,0.5".
+type floatList []float64
+
+func (l *floatList) String() string {
+	if l == nil {
+		return ""
+	}
+	values := make([]string, len(*l))
+	for i, v := range *l {
+		values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *floatList) Set(value string) error {
+	values := []float64{}
+	for _, field := range strings.Split(value, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	*l = values
+	return nil
+}
+
+// Comma separated list of ints, e.g. "10,20,50".
+type intList []int
+
+func (l *intList) String() string {
+	if l == nil {
+		return ""
+	}
+	values := make([]string, len(*l))
+	for i, v := range *l {
+		values[i] = strconv.Itoa(v)
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *intList) Set(value string) error {
+	values := []int{}
+	for _, field := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	*l = values
+	return nil
+}
+
+// Comma separated list of risk variances, e.g. "low,medium,high".
+type varianceList []simulate.RiskVariance
+
+func (l *varianceList) String() string {
+	if l == nil {
+		return ""
+	}
+	values := make([]string, len(*l))
+	for i, v := range *l {
+		values[i] = string(v)
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *varianceList) Set(value string) error {
+	values := []simulate.RiskVariance{}
+	for _, field := range strings.Split(value, ",") {
+		variance := simulate.RiskVariance(strings.TrimSpace(field))
+		switch variance {
+		case simulate.LowVar, simulate.MediumVar, simulate.HighVar:
+			values = append(values, variance)
+		default:
+			return fmt.Errorf("unknown risk variance %q", field)
+		}
+	}
+	*l = values
+	return nil
+}
+
+// Run type flag, restricted to the models the simulate package knows about.
+type runTypeFlag simulate.RunType
+
+func (r *runTypeFlag) String() string {
+	return string(*r)
+}
+
+func (r *runTypeFlag) Set(value string) error {
+	runType := simulate.RunType(value)
+	switch runType {
+	case simulate.Simulation, simulate.DifEq, simulate.Difference, simulate.Analytic:
+		*r = runTypeFlag(runType)
+		return nil
+	}
+	return fmt.Errorf("unknown run type %q", value)
+}
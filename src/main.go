@@ -2,140 +2,531 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/pprof"
 	"time"
 
 	"github.com/brendanwallace/hotspot/simulate"
-	"golang.org/x/exp/rand"
 )
 
+// Defaults for the command line flags.
 const N = 1000
 const TRIALS = 1000
 const DISEASE_PERIOD int = 1
-const GAMMA float64 = 1.0 / float64(DISEASE_PERIOD)
 const RUN_TYPE simulate.RunType = simulate.Simulation
 const DATA_LOCATION = "../data/"
 
-const PROFILE = false
-
 // Give read/write to user and read to all others.
 const DATA_FILE_PERMISSIONS = 0644
 
+const USAGE = `usage: hotspot <command> [flags]
+
+commands:
+  sweep   vary R0 over a grid of hotspot fractions and risk distributions
+  run     run a single parameter point
+  info    print the model constants and flag defaults
+  convergence
+          compare FinalR over risk discretisations and bucket counts
+
+Run 'hotspot <command> -h' for the flags of a command.
+`
+
+// Settings shared by every command that runs a model.
+type runConfig struct {
+	N             int
+	Trials        int
+	DiseasePeriod int
+	RunType       runTypeFlag
+	DataLocation  string
+	Profile       string
+	// Number of parameter points, and of trials within a point, run at once.
+	Workers      int
+	TrialWorkers int
+	// Zero picks a seed from the clock; either way it is saved with the results.
+	Seed   uint64
+	Record string
+	// When to stop simulation trials early.
+	Extinction        string
+	ExtinctionCutoff  int
+	PredictExtinction bool
+	// Latent period, and the number of stages of each period.
+	LatentPeriod     int
+	LatentStages     int
+	InfectiousStages int
+	// Waning immunity, and the day to stop at.
+	WaningRate float64
+	MaxTime    float64
+	// How the differential equation is integrated.
+	Integrator string
+	// Risk buckets of the differential and difference equations.
+	Buckets        int
+	Discretisation string
+}
+
+func (c *runConfig) register(fs *flag.FlagSet) {
+	c.RunType = runTypeFlag(RUN_TYPE)
+	fs.IntVar(&c.N, "n", N, "number of individuals")
+	fs.IntVar(&c.Trials, "trials", TRIALS, "number of trials per parameter point (simulation only)")
+	fs.IntVar(&c.DiseasePeriod, "disease-period", DISEASE_PERIOD, "days an individual stays infected")
+	fs.Var(&c.RunType, "run-type", "model to run: simulation, difeq, difference or analytic")
+	fs.StringVar(&c.DataLocation, "data", DATA_LOCATION, "directory to write results to")
+	fs.StringVar(&c.Profile, "profile", "", "write a CPU profile to this file")
+	fs.IntVar(&c.Workers, "workers", runtime.NumCPU(), "number of parameter points to run in parallel")
+	fs.IntVar(&c.TrialWorkers, "trial-workers", 1, "number of trials of a point to run in parallel (simulation only)")
+	fs.Uint64Var(&c.Seed, "seed", 0, "random seed (0 picks one from the clock)")
+	fs.StringVar(&c.Record, "record", "",
+		"trajectory to save for each simulation trial: empty for none, compartments or all")
+	fs.StringVar(&c.Extinction, "extinction", "",
+		"when to stop simulation trials early: empty to stop at the cutoff, decided or full")
+	fs.IntVar(&c.ExtinctionCutoff, "extinction-cutoff", simulate.EXTINCTION_CUTOFF,
+		"number of infections that decides a trial didn't go extinct")
+	fs.BoolVar(&c.PredictExtinction, "predict-extinction", false,
+		"also compute the branching process's extinction probability of simulations")
+	fs.IntVar(&c.LatentPeriod, "latent-period", 0, "days an individual is exposed before becoming infectious")
+	fs.IntVar(&c.LatentStages, "latent-stages", 0,
+		"number of stages of the latent period (0 for a fixed period, or a single exponential one in difeq)")
+	fs.IntVar(&c.InfectiousStages, "infectious-stages", 0,
+		"number of stages of the infectious period (0 for a fixed period, or a single exponential one in difeq)")
+	fs.Float64Var(&c.WaningRate, "waning-rate", 0, "rate per day at which the recovered become susceptible again")
+	fs.Float64Var(&c.MaxTime, "max-time", 0, "day to stop every run at (0 for no limit; required with -waning-rate)")
+	fs.StringVar(&c.Integrator, "integrator", "",
+		"integrator for difeq runs: empty for forward Euler, rk4, rk45 or implicit")
+	fs.IntVar(&c.Buckets, "buckets", 0, "number of risk buckets of difeq and difference runs (0 for BUCKETS)")
+	fs.StringVar(&c.Discretisation, "discretisation", "",
+		"risk buckets of difeq and difference runs: empty for equal widths, gauss-jacobi or quantile")
+}
+
+func (c runConfig) validate() error {
+	if c.N <= 0 {
+		return fmt.Errorf("-n must be positive, got %d", c.N)
+	}
+	if c.Trials <= 0 {
+		return fmt.Errorf("-trials must be positive, got %d", c.Trials)
+	}
+	if c.DiseasePeriod <= 0 {
+		return fmt.Errorf("-disease-period must be positive, got %d", c.DiseasePeriod)
+	}
+	if c.Workers <= 0 || c.TrialWorkers <= 0 {
+		return fmt.Errorf("-workers and -trial-workers must be positive, got %d and %d", c.Workers, c.TrialWorkers)
+	}
+	return nil
+}
+
+func (c runConfig) runType() simulate.RunType {
+	return simulate.RunType(c.RunType)
+}
+
+// The seed to run with: the one given, or one from the clock if none was.
+func (c runConfig) seed() uint64 {
+	if c.Seed != 0 {
+		return c.Seed
+	}
+	return uint64(time.Now().UnixNano())
+}
+
+func (c runConfig) title() string {
+	return fmt.Sprintf("%s,D=%d,T=%d", c.runType(), c.DiseasePeriod, c.Trials)
+}
+
+// The settings that are the same at every point of a sweep.
+func (c runConfig) base() simulate.Parameters {
+	return simulate.Parameters{
+		Record:            simulate.RecordLevel(c.Record),
+		Extinction:        simulate.ExtinctionMode(c.Extinction),
+		ExtinctionCutoff:  c.ExtinctionCutoff,
+		PredictExtinction: c.PredictExtinction,
+		LatentLength:      c.LatentPeriod,
+		LatentStages:      c.LatentStages,
+		InfectiousStages:  c.InfectiousStages,
+		WaningRate:        c.WaningRate,
+		MaxTime:           c.MaxTime,
+		Integrator:        simulate.IntegratorKind(c.Integrator),
+		Buckets:           c.Buckets,
+		Discretisation:    simulate.Discretisation(c.Discretisation),
+	}
+}
+
+// Builds the model parameters for a single point: R0 is split between the
+// community and the hotspot according to hotspotFraction.
+func (c runConfig) parameters(R0, hotspotFraction, riskMean float64, riskVariance simulate.RiskVariance) simulate.Parameters {
+	param := c.base()
+	param.DiseaseLength = c.DiseasePeriod
+	param.N = c.N
+	param.RunType = c.runType()
+	param.R0 = R0
+	param.HotspotFraction = hotspotFraction
+	param.RiskMean = riskMean
+	param.RiskVariance = riskVariance
+	param.Trials = c.Trials
+	param.Seed = c.seed()
+	param.ComputeBetas()
+	return param
+}
+
+// Axes of an R0 sweep.
+type sweepConfig struct {
+	runConfig
+	HotspotFractions floatList
+	RiskMeans        floatList
+	RiskVariances    varianceList
+	EndR0            float64
+	R0Step           float64
+	// If set, the sweep is read from this experiment file instead.
+	Experiment string
+	Format     string
+	// Finished points are saved to, and skipped if already in, this file.
+	Checkpoint string
+}
+
+func (c *sweepConfig) register(fs *flag.FlagSet) {
+	c.runConfig.register(fs)
+	// These are typically [0.0, 0.25, 0.5, 0.75]
+	c.HotspotFractions = floatList{0.0, 0.25, 0.5, 0.75}
+	// For the main text, we show [0.5, 0.25, 0.125]. For the SI we show a
+	// wider range: 0.75,0.5,0.25,0.125,0.06,0.03
+	c.RiskMeans = floatList{0.5, 0.25, 0.125}
+	c.RiskVariances = varianceList{simulate.LowVar, simulate.MediumVar, simulate.HighVar}
+	fs.Var(&c.HotspotFractions, "hotspot-fractions", "comma separated fractions of R0 due to the hotspot")
+	fs.Var(&c.RiskMeans, "risk-means", "comma separated risk tolerance means")
+	fs.Var(&c.RiskVariances, "risk-variances", "comma separated risk tolerance variances (low, medium, high)")
+	fs.Float64Var(&c.EndR0, "r0-end", 1.0, "largest R0 in the sweep")
+	fs.Float64Var(&c.R0Step, "r0-step", 0.01, "R0 increment")
+	fs.StringVar(&c.Experiment, "experiment", "",
+		"read the sweep from this experiment file (.json, .yaml or .toml) instead of the axis flags")
+	fs.StringVar(&c.Format, "format", FORMAT_JSON,
+		"output format: json (nested, written at the end), or csv, jsonl or parquet (one row per run, written as points finish)")
+	fs.StringVar(&c.Checkpoint, "checkpoint", "",
+		"save finished points to this file, and resume from the points it already holds")
+}
+
+func (c sweepConfig) validate() error {
+	if err := c.runConfig.validate(); err != nil {
+		return err
+	}
+	switch c.Format {
+	case FORMAT_JSON, FORMAT_CSV, FORMAT_JSONL, FORMAT_PARQUET:
+	default:
+		return fmt.Errorf("unknown -format %q", c.Format)
+	}
+	if c.Experiment == "" && c.R0Step <= 0 {
+		return fmt.Errorf("-r0-step must be positive, got %v", c.R0Step)
+	}
+	return nil
+}
+
 func main() {
-	// Start profiling
-	if PROFILE {
-		f, err := os.Create("hotspot.prof")
-		if err != nil {
-			fmt.Println(err)
-			return
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, USAGE)
+		os.Exit(2)
+	}
 
-		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "sweep":
+		err = sweepCommand(args)
+	case "run":
+		err = runCommand(args)
+	case "info":
+		err = infoCommand(args)
+	case "convergence":
+		err = convergenceCommand(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(USAGE)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, USAGE)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func sweepCommand(args []string) error {
+	var config sweepConfig
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	config.register(fs)
+	fs.Parse(args)
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	stop, err := startProfile(config.Profile)
+	if err != nil {
+		return err
 	}
+	defer stop()
 
-	title := fmt.Sprintf("%s,D=%d,T=%d", RUN_TYPE, DISEASE_PERIOD, TRIALS)
+	experiment, err := config.experiment()
+	if err != nil {
+		return err
+	}
+
+	// A resumed sweep must run with the seed it started with, so the seed is
+	// only picked from the clock once the checkpoint has had its say.
+	var cp *checkpoint
+	if config.Checkpoint != "" {
+		if cp, err = loadCheckpoint(config.Checkpoint); err != nil {
+			return err
+		}
+		if err := cp.resume(&experiment); err != nil {
+			return err
+		}
+	}
+	if experiment.Seed == 0 {
+		experiment.Seed = config.seed()
+	}
+	// Expanding validates the Parameters of every point, whose checks depend
+	// on the run type, before anything is written.
+	allSeries, err := experiment.Expand()
+	if err != nil {
+		return err
+	}
+	if cp != nil {
+		if err := cp.open(experiment); err != nil {
+			cp.Close()
+			return err
+		}
+		defer cp.Close()
+	}
+	title := experiment.Name
+	if title == "" {
+		title = config.title()
+	}
 	fmt.Println(title)
-	var results = runR0Series(RUN_TYPE)
-	write(results, title)
+
+	meta := newMetadata(experiment.Seed)
+	out, err := newSink(config.Format, filepath.Join(config.DataLocation, title), meta, experiment, allSeries)
+	if err != nil {
+		return err
+	}
+	err = runExperiment(allSeries, config.runConfig, out, cp)
+	fmt.Println()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func write(results interface{}, filename string) {
+func runCommand(args []string) error {
+	var config runConfig
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	config.register(fs)
+	R0 := fs.Float64("r0", 2.0, "basic reproduction number")
+	hotspotFraction := fs.Float64("hotspot-fraction", 0.5, "fraction of R0 due to the hotspot")
+	riskMean := fs.Float64("risk-mean", 0.25, "risk tolerance mean")
+	riskVariance := varianceList{simulate.MediumVar}
+	fs.Var(&riskVariance, "risk-variance", "risk tolerance variance (low, medium, high)")
+	fs.Parse(args)
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if len(riskVariance) != 1 {
+		return fmt.Errorf("-risk-variance takes a single value, got %v", riskVariance.String())
+	}
+
+	params := config.parameters(*R0, *hotspotFraction, *riskMean, riskVariance[0])
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	stop, err := startProfile(config.Profile)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	meta := newMetadata(params.Seed)
+	runSet, err := routeRun(params, config.TrialWorkers)
+	if err != nil {
+		return err
+	}
+	meta.finish()
+
+	finalR, maxI := 0.0, 0.0
+	for _, run := range runSet.Runs {
+		finalR += run.FinalR / float64(len(runSet.Runs))
+		maxI += run.MaxI / float64(len(runSet.Runs))
+	}
+	fmt.Printf("%s R0=%v seed=%d runs=%d mean FinalR=%.3f mean MaxI=%.3f\n",
+		config.runType(), *R0, params.Seed, len(runSet.Runs), finalR, maxI)
+
+	title := fmt.Sprintf("%s,R0=%v,H=%v,M=%v,V=%s", config.title(),
+		*R0, *hotspotFraction, *riskMean, riskVariance[0])
+	return write(struct {
+		Metadata metadata
+		simulate.RunSet
+	}{meta, runSet}, config.DataLocation, title)
+}
+
+// Prints how FinalR of a difeq or difference point converges as the number
+// of risk buckets grows, for each discretisation.
+func convergenceCommand(args []string) error {
+	var config runConfig
+	fs := flag.NewFlagSet("convergence", flag.ExitOnError)
+	config.register(fs)
+	R0 := fs.Float64("r0", 2.0, "basic reproduction number")
+	hotspotFraction := fs.Float64("hotspot-fraction", 0.5, "fraction of R0 due to the hotspot")
+	riskMean := fs.Float64("risk-mean", 0.25, "risk tolerance mean")
+	riskVariance := varianceList{simulate.HighVar}
+	fs.Var(&riskVariance, "risk-variance", "risk tolerance variance (low, medium, high)")
+	bucketCounts := intList{5, 10, 20, 50, 100, 200}
+	fs.Var(&bucketCounts, "bucket-counts", "numbers of risk buckets to compare")
+	fs.Parse(args)
+	if config.RunType == runTypeFlag(simulate.Simulation) {
+		config.RunType = runTypeFlag(simulate.DifEq)
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if len(riskVariance) != 1 {
+		return fmt.Errorf("-risk-variance takes a single value, got %v", riskVariance.String())
+	}
+	for _, n := range bucketCounts {
+		if n <= 0 {
+			return fmt.Errorf("-bucket-counts must be positive, got %d", n)
+		}
+	}
+
+	params := config.parameters(*R0, *hotspotFraction, *riskMean, riskVariance[0])
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	points, err := simulate.DiscretisationConvergence(params, bucketCounts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s R0=%v H=%v M=%v V=%s, error against %d %s buckets\n", config.runType(), *R0,
+		*hotspotFraction, *riskMean, riskVariance[0], simulate.REFERENCE_BUCKETS, simulate.GaussJacobiNodes)
+	fmt.Printf("%-14s %8s %12s %12s\n", "discretisation", "buckets", "FinalR", "error")
+	for _, point := range points {
+		name := string(point.Discretisation)
+		if name == "" {
+			name = "midpoint"
+		}
+		fmt.Printf("%-14s %8d %12.4f %12.3g\n", name, point.Buckets, point.FinalR, point.Error)
+	}
+	return nil
+}
+
+func infoCommand(args []string) error {
+	var config sweepConfig
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	config.register(fs)
+	fs.Parse(args)
+
+	fmt.Println("model constants:")
+	fmt.Printf("  DT=%v BUCKETS=%v INITIAL_INFECTEDS=%v END_THRESHOLD=%v\n",
+		simulate.DT, simulate.BUCKETS, simulate.INITIAL_INFECTEDS, simulate.END_THRESHOLD)
+	fmt.Printf("  INITIAL_INFECTED=%v EXTINCTION_CUTOFF=%v OUTBREAK_THRESHOLD=%v\n",
+		simulate.INITIAL_INFECTED, simulate.EXTINCTION_CUTOFF, simulate.OUTBREAK_THRESHOLD)
+	fmt.Printf("  DIFEQ_SAVE_STEPS=%v RK45_TOLERANCE=%v RK45_MIN_STEP=%v REFERENCE_BUCKETS=%v\n",
+		simulate.DIFEQ_SAVE_STEPS, simulate.RK45_TOLERANCE, simulate.RK45_MIN_STEP, simulate.REFERENCE_BUCKETS)
+	fmt.Printf("  FINAL_SIZE_TOLERANCE=%v EXTINCTION_TOLERANCE=%v EXTINCTION_ITERATIONS=%v\n",
+		simulate.FINAL_SIZE_TOLERANCE, simulate.EXTINCTION_TOLERANCE, simulate.EXTINCTION_ITERATIONS)
+	fmt.Printf("  RESPONSIVENESS_CLASSES=%v INFECTIOUSNESS_CLASSES=%v\n",
+		simulate.RESPONSIVENESS_CLASSES, simulate.INFECTIOUSNESS_CLASSES)
+	fmt.Println("run types:")
+	fmt.Printf("  %s, %s, %s, %s\n", simulate.Simulation, simulate.DifEq, simulate.Difference, simulate.Analytic)
+	fmt.Println("flags:")
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+	return nil
+}
+
+// Starts CPU profiling if a file name is given; the returned function stops it.
+func startProfile(fileName string) (func(), error) {
+	if fileName == "" {
+		return func() {}, nil
+	}
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+	}, nil
+}
+
+func write(results interface{}, dataLocation string, filename string) error {
 	fileName := fmt.Sprintf("%s.json", filename)
 
 	// Output to appropriately named file
 	file, jsonErr := json.MarshalIndent(results, "", "\t")
 	if jsonErr != nil {
-		log.Fatal(jsonErr)
+		return jsonErr
 	}
 
-	writeFileErr := ioutil.WriteFile(DATA_LOCATION+fileName, file, DATA_FILE_PERMISSIONS)
-	if writeFileErr != nil {
-		log.Fatal(writeFileErr)
-	}
+	return ioutil.WriteFile(filepath.Join(dataLocation, fileName), file, DATA_FILE_PERMISSIONS)
 }
 
-func routeRun(runType simulate.RunType, param simulate.Parameters) simulate.RunSet {
-	switch runType {
+func routeRun(param simulate.Parameters, trialWorkers int) (simulate.RunSet, error) {
+	switch param.RunType {
 	case simulate.Simulation:
-		return simulate.RunSimulation(param)
+		return simulate.RunSimulationWorkers(param, trialWorkers), nil
 	case simulate.DifEq:
-		return simulate.RunDifEq(param)
+		return simulate.RunDifEq(param), nil
 	case simulate.Difference:
 		return simulate.RunDifference(param)
+	case simulate.Analytic:
+		return simulate.RunAnalytic(param)
 	default:
-		return simulate.RunSet{}
+		return simulate.RunSet{}, fmt.Errorf("unknown run type %q", param.RunType)
 	}
 }
 
-// Consider 9 different risk distributions
-// For each one, vary R0 from 0.0 -> 8.0
-func runR0Series(runType simulate.RunType) []simulate.R0Series {
-
-	// vary R0 0 -> EndR0
+// Runs every point of the expanded experiment, config.Workers at a time, and
+// passes the results to out in the same order as a sequential run. Points
+// already in the checkpoint, if there is one, are taken from it instead of
+// being run again, and newly finished points are added to it.
+func runExperiment(allSeries []simulate.R0Series, config runConfig, out sink, cp *checkpoint) error {
 
-	const EndR0 = 1.0
-	const R0Step = 0.01
-
-	rand.Seed(uint64(time.Now().UnixNano()))
+	// Flatten the series into a single list of jobs.
+	jobs := []simulate.Parameters{}
+	for _, series := range allSeries {
+		for _, runSet := range series.RunSets {
+			jobs = append(jobs, runSet.Parameters)
+		}
+	}
 
-	// These are typically [0.0, 0.25, 0.5, 0.75]
-	hotspotFractions := []float64{0.0, 0.25, 0.5, 0.75}
-	// For the main text, we show [0.5, 0.25, 0.125]. For the SI we show this wider range.
-	// riskMeans := []float64{0.75, 0.5, 0.25, 0.125, 0.06, 0.03}
-	riskMeans := []float64{0.5, 0.25, 0.125}
-
-	// simulate.LowVar, simulate.MediumVar, simulate.HighVar
-	riskVariances := []simulate.RiskVariance{simulate.LowVar, simulate.MediumVar, simulate.HighVar}
-	allSeries := []simulate.R0Series{}
-
-	for hsf, hotspotFraction := range hotspotFractions {
-		for rm, riskMean := range riskMeans {
-			for rv, riskVariance := range riskVariances {
-				series := simulate.R0Series{
-					RunType:         runType,
-					RiskMean:        riskMean,
-					RiskVariance:    riskVariance,
-					HotspotFraction: hotspotFraction,
-					RunSets:         make([]simulate.RunSet, 0),
-				}
-
-				for R0 := 0.0; R0 <= EndR0; R0 += R0Step {
-
-					fmt.Printf("\r hotspotfraction=%v/%v riskmean=%v/%v riskvar=%v/%v R0=%f",
-						hsf+1, len(hotspotFractions),
-						rm+1, len(riskMeans),
-						rv+1, len(riskVariances),
-						R0,
-					)
-
-					var betaR float64
-					if riskMean == 0 {
-						betaR = 0
-					} else {
-						betaR = GAMMA * (R0 * hotspotFraction / riskMean / riskMean) / N
-					}
-
-					params := simulate.Parameters{
-						BetaC:         GAMMA * (R0 * (1 - hotspotFraction)) / N,
-						BetaR:         betaR,
-						DiseaseLength: DISEASE_PERIOD,
-						N:             N,
-						R0:            R0,
-						Trials:        TRIALS,
-						RiskDist:      simulate.RiskDist(riskMean, riskVariance),
-					}
-
-					series.RunSets = append(series.RunSets, routeRun(runType, params))
-
-				}
-				allSeries = append(allSeries, series)
+	run := func(param simulate.Parameters) (simulate.RunSet, error) {
+		if cp != nil {
+			if runSet, ok := cp.lookup(param); ok {
+				return runSet, nil
 			}
 		}
+		return routeRun(param, config.TrialWorkers)
 	}
-	return allSeries
+	// Keep going after a failed write, so the workers can finish, but only
+	// report the first error. A failed point stops the sweep, and is the
+	// last one emitted.
+	var firstErr error
+	execute(jobs, config.Workers, run, func(i int, runSet simulate.RunSet, err error) {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("point %d, %s R0=%v: %v", i+1, jobs[i].RunType, jobs[i].R0, err)
+			}
+			return
+		}
+		fmt.Printf("\r points=%v/%v R0=%f", i+1, len(jobs), runSet.Parameters.R0)
+		if cp != nil && firstErr == nil {
+			if _, ok := cp.lookup(runSet.Parameters); !ok {
+				firstErr = cp.save(runSet)
+			}
+		}
+		if firstErr == nil {
+			firstErr = out.Write(runSet)
+		}
+	})
+	return firstErr
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/brendanwallace/hotspot/simulate"
+)
+
+// The version of what the output's fields mean, bumped whenever one of them
+// changes. In version 2 the differential equation's Duration, PeakTime and
+// Waves are in days; in version 1, which files without a version are, they
+// were a tenth of that.
+const OUTPUT_VERSION = 2
+
+// Records what produced an output file, so that a figure can be traced back
+// to the exact code, settings and machine behind it.
+type metadata struct {
+	OutputVersion int
+	Seed          uint64
+	// Empty if the commit couldn't be found; Modified means there were
+	// uncommitted changes.
+	Commit    string `json:",omitempty"`
+	Modified  bool   `json:",omitempty"`
+	GoVersion string
+	Constants constants
+	Command   []string
+	Host      string `json:",omitempty"`
+	Started   time.Time
+	// Only known to formats written once the run is over.
+	Finished *time.Time `json:",omitempty"`
+}
+
+// The model constants the results depend on.
+type constants struct {
+	BUCKETS               int
+	DT                    float64
+	INITIAL_INFECTEDS     float64
+	END_THRESHOLD         float64
+	OUTBREAK_THRESHOLD    float64
+	EXTINCTION_CUTOFF     int
+	INITIAL_INFECTED      int
+	DIFEQ_SAVE_STEPS      int
+	RK45_TOLERANCE        float64
+	RK45_MIN_STEP         float64
+	REFERENCE_BUCKETS     int
+	FINAL_SIZE_TOLERANCE  float64
+	EXTINCTION_TOLERANCE  float64
+	EXTINCTION_ITERATIONS int
+
+	RESPONSIVENESS_CLASSES int
+	INFECTIOUSNESS_CLASSES int
+}
+
+// The metadata of a run starting now with the given seed.
+func newMetadata(seed uint64) metadata {
+	commit, modified := gitCommit()
+	host, _ := os.Hostname()
+	return metadata{
+		OutputVersion: OUTPUT_VERSION,
+		Seed:          seed,
+		Commit:        commit,
+		Modified:      modified,
+		GoVersion:     runtime.Version(),
+		Constants: constants{
+			BUCKETS:               simulate.BUCKETS,
+			DT:                    simulate.DT,
+			INITIAL_INFECTEDS:     simulate.INITIAL_INFECTEDS,
+			END_THRESHOLD:         simulate.END_THRESHOLD,
+			OUTBREAK_THRESHOLD:    simulate.OUTBREAK_THRESHOLD,
+			EXTINCTION_CUTOFF:     simulate.EXTINCTION_CUTOFF,
+			INITIAL_INFECTED:      simulate.INITIAL_INFECTED,
+			DIFEQ_SAVE_STEPS:      simulate.DIFEQ_SAVE_STEPS,
+			RK45_TOLERANCE:        simulate.RK45_TOLERANCE,
+			RK45_MIN_STEP:         simulate.RK45_MIN_STEP,
+			REFERENCE_BUCKETS:     simulate.REFERENCE_BUCKETS,
+			FINAL_SIZE_TOLERANCE:  simulate.FINAL_SIZE_TOLERANCE,
+			EXTINCTION_TOLERANCE:  simulate.EXTINCTION_TOLERANCE,
+			EXTINCTION_ITERATIONS: simulate.EXTINCTION_ITERATIONS,
+
+			RESPONSIVENESS_CLASSES: simulate.RESPONSIVENESS_CLASSES,
+			INFECTIOUSNESS_CLASSES: simulate.INFECTIOUSNESS_CLASSES,
+		},
+		Command: os.Args,
+		Host:    host,
+		Started: time.Now(),
+	}
+}
+
+// Marks the run as finished now.
+func (m *metadata) finish() {
+	finished := time.Now()
+	m.Finished = &finished
+}
+
+// The commit the binary was built from, as stamped by go build, or else the
+// commit checked out in the working directory, which is what go run uses.
+func gitCommit() (string, bool) {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		commit, modified := "", false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if commit != "" {
+			return commit, modified
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+	return strings.TrimSpace(string(out)), err == nil && len(status) > 0
+}
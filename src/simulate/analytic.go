@@ -0,0 +1,341 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// The solution of the final size equation is refined until a step changes
+// it by less than this fraction of the population.
+const FINAL_SIZE_TOLERANCE = 1e-10
+
+// The mean-field model reduced to what its final size, threshold and growth
+// rate depend on. Everyone infected in class c adds infectiousness[c] to the
+// infectious contacts of their community and, times their participation
+// q[k][c], to those of each hotspot k; these are the contact groups j, with
+// transmission rates beta[j]. Class c is infected at rate exposure[j][c]
+// times beta[j] times the contacts of group j, summed over the groups:
+// exposure is 1 in its own community and q[k][c] at hotspot k.
+type meanField struct {
+	cls classes
+	// Everyone in each class who can be infected, i.e. everyone but the
+	// immune, and the susceptible among them at the start.
+	P, S0 []float64
+	// The weight of each class in each contact group, and its exposure to
+	// it.
+	weight, exposure [][]float64
+	beta             []float64
+	diseaseLength    float64
+}
+
+func newMeanField(param Parameters) meanField {
+	cls, S, I, _ := initializeClasses(param)
+	// Pre-existing immunity, the only vaccination RunAnalytic takes.
+	if campaigns := cls.campaigns(param.Vaccinations, initialPopulation(S, I)); campaigns != nil {
+		vaccinateClasses(campaigns, 0, DT, param.N, S, append([]float64{}, S...))
+	}
+	communities, hotspots := param.communities(), param.hotspots()
+	q := newChain(len(hotspots), len(S))
+	cls.participation(q, hotspots, 0, 0, float64(param.N))
+
+	groups := len(communities) + len(hotspots)
+	m := meanField{
+		cls:           cls,
+		P:             initialPopulation(S, I),
+		S0:            S,
+		weight:        newChain(groups, len(S)),
+		exposure:      newChain(groups, len(S)),
+		beta:          make([]float64, groups),
+		diseaseLength: float64(param.DiseaseLength),
+	}
+	for i, community := range communities {
+		m.beta[i] = community.BetaC
+	}
+	for k, h := range hotspots {
+		j := len(communities) + k
+		m.beta[j] = h.BetaR
+		for c := range S {
+			m.weight[j][c] = q[k][c] * cls.infectiousness[c]
+			m.exposure[j][c] = q[k][c]
+		}
+	}
+	for c := range S {
+		m.weight[cls.community[c]][c] = cls.infectiousness[c]
+		m.exposure[cls.community[c]][c] = 1
+	}
+	return m
+}
+
+// The weighted totals of x in every contact group.
+func (m meanField) contacts(x []float64) []float64 {
+	totals := make([]float64, len(m.weight))
+	for j, weight := range m.weight {
+		totals[j] = weightedSum(x, weight)
+	}
+	return totals
+}
+
+// The susceptible left in each class once the contact groups have had the
+// given totals of infected go through them: everyone infected is infectious
+// for DiseaseLength on average, whatever the stages, so each class escapes
+// with probability exp(-sum_j beta[j] exposure[j][c] DiseaseLength total[j]).
+func (m meanField) escaped(infected []float64) []float64 {
+	S := make([]float64, len(m.S0))
+	for c := range S {
+		hazard := 0.0
+		for j, total := range infected {
+			hazard += m.beta[j] * m.exposure[j][c] * total
+		}
+		S[c] = m.S0[c] * math.Exp(-hazard*m.diseaseLength)
+	}
+	return S
+}
+
+// Everyone ever infected, from the final size equation: the infected totals
+// of the contact groups, F, are a zero of G(F) = F - contacts(P -
+// escaped(F)). G is convex, so Newton's method from everyone infected falls
+// to its largest zero, which is the final size whenever anyone is infected
+// to begin with.
+func (m meanField) finalSize() ([]float64, error) {
+	groups := len(m.beta)
+	F := m.contacts(m.P)
+	for iteration := 0; iteration < 100; iteration++ {
+		S := m.escaped(F)
+		ever := make([]float64, len(S))
+		for c := range ever {
+			ever[c] = m.P[c] - S[c]
+		}
+		G := mat.NewVecDense(groups, m.contacts(ever))
+		G.SubVec(mat.NewVecDense(groups, append([]float64{}, F...)), G)
+		// dG[j]/dF[l] = delta[j][l] - sum_c weight[j][c] S[c] DiseaseLength
+		// beta[l] exposure[l][c].
+		jacobian := mat.NewDense(groups, groups, nil)
+		for j := range m.beta {
+			for l := range m.beta {
+				derivative := -m.diseaseLength * m.beta[l] * weightedSum2(S, m.weight[j], m.exposure[l])
+				if j == l {
+					derivative++
+				}
+				jacobian.Set(j, l, derivative)
+			}
+		}
+		var step mat.VecDense
+		if err := step.SolveVec(jacobian, G); err != nil {
+			return nil, fmt.Errorf("solving the final size equation: %v", err)
+		}
+		change := 0.0
+		for j := range F {
+			F[j] -= step.AtVec(j)
+			change = math.Max(change, math.Abs(step.AtVec(j)))
+		}
+		if change < FINAL_SIZE_TOLERANCE*sum(m.P) {
+			S := m.escaped(F)
+			for c := range ever {
+				ever[c] = m.P[c] - S[c]
+			}
+			return ever, nil
+		}
+	}
+	return nil, fmt.Errorf("the final size equation didn't converge")
+}
+
+// The spectral radius of the next generation matrix of the infection-free
+// population, where everyone who can be infected is susceptible: the nonzero
+// eigenvalues of the class by class matrix are those of the matrix between
+// contact groups, G[j][l] = DiseaseLength * beta[j] * sum_c weight[j][c] P[c]
+// exposure[l][c].
+func (m meanField) reproductionNumber() (float64, error) {
+	groups := len(m.beta)
+	G := mat.NewDense(groups, groups, nil)
+	for j := range m.beta {
+		for l := range m.beta {
+			G.Set(j, l, m.diseaseLength*m.beta[j]*weightedSum2(m.P, m.weight[j], m.exposure[l]))
+		}
+	}
+	var eigen mat.Eigen
+	if !eigen.Factorize(G, mat.EigenNone) {
+		return 0, errors.New("the eigenvalues of the next generation matrix didn't converge")
+	}
+	radius := 0.0
+	for _, value := range eigen.Values(nil) {
+		radius = math.Max(radius, cmplx.Abs(value))
+	}
+	return radius, nil
+}
+
+// The expected number of people someone newly infected infects, in the
+// differential equation, of the whole population at the start. The epidemic
+// takes off if it is above 1. This is R0 itself only if everyone takes the
+// same risks: at the hotspot the risk takers both infect and are infected
+// more.
+func ReproductionNumber(param Parameters) (float64, error) {
+	return newMeanField(param).reproductionNumber()
+}
+
+// The R0 above which the epidemic takes off, with every transmission rate
+// scaled alike.
+func EpidemicThreshold(param Parameters) (float64, error) {
+	reproductionNumber, err := ReproductionNumber(param)
+	if err != nil {
+		return 0, err
+	}
+	return param.R0 / reproductionNumber, nil
+}
+
+// The initial exponential growth rate of the differential equation, per
+// day: the r with ReproductionNumber * L(r) = 1, where L is the Laplace
+// transform of the generation interval: the latent period, then a time into
+// the infectious period with density P(still infectious) / DiseaseLength.
+// It is negative if the epidemic dies out.
+func GrowthRate(param Parameters) (float64, error) {
+	reproductionNumber, err := ReproductionNumber(param)
+	if err != nil {
+		return 0, err
+	}
+	return growthRate(reproductionNumber, param), nil
+}
+
+func growthRate(reproductionNumber float64, param Parameters) float64 {
+	latent := continuousStages(param.LatentLength, param.LatentStages)
+	infectious := continuousStages(param.DiseaseLength, param.InfectiousStages)
+	transform := func(r float64) float64 {
+		L := 1.0
+		if latent.n > 0 {
+			L = math.Pow(latent.leave/(latent.leave+r), float64(latent.n))
+		}
+		if r != 0 {
+			L *= (1 - math.Pow(infectious.leave/(infectious.leave+r), float64(infectious.n))) / (r * float64(param.DiseaseLength))
+		}
+		return reproductionNumber * L
+	}
+	// The transform falls from infinity at the slowest stage's rate.
+	lower := -infectious.leave
+	if latent.n > 0 {
+		lower = math.Max(lower, -latent.leave)
+	}
+	upper := 1.0
+	for transform(upper) > 1 {
+		upper *= 2
+	}
+	for i := 0; i < 200; i++ {
+		middle := (lower + upper) / 2
+		if transform(middle) > 1 {
+			lower = middle
+		} else {
+			upper = middle
+		}
+	}
+	return (lower + upper) / 2
+}
+
+// The course of the epidemic when the infectious period is a single
+// exponential stage and there is no latent period: then the state reduces to
+// the contact groups' totals of recovered, F, and the total recovered, Z.
+// Since S = escaped(F) and everyone else who can be infected is infectious
+// or recovered, F' = (contacts(P - S) - F) / DiseaseLength, and likewise Z.
+// Integrated with RK4 steps of DT * DIFEQ_SAVE_STEPS, which the smooth
+// reduced state allows, until fewer than END_THRESHOLD people are infected.
+// Returns the infected at every step, and the highest number infected.
+func (m meanField) course(param Parameters) ([]float64, []float64, float64) {
+	total := sum(m.P)
+	groups := len(m.beta)
+	ever := make([]float64, len(m.P))
+	// Sets dy to the derivative at y, and returns the number infected.
+	derivative := func(y []float64, dy []float64) float64 {
+		S := m.escaped(y[:groups])
+		for c := range ever {
+			ever[c] = m.P[c] - S[c]
+		}
+		for j, weight := range m.weight {
+			dy[j] = (weightedSum(ever, weight) - y[j]) / m.diseaseLength
+		}
+		infected := total - sum(S) - y[groups]
+		dy[groups] = infected / m.diseaseLength
+		return infected
+	}
+
+	h := DT * DIFEQ_SAVE_STEPS
+	y := make([]float64, groups+1)
+	k := newChain(4, len(y))
+	next := make([]float64, len(y))
+	Ts, Is := []float64{}, []float64{}
+	maxInfected := 0.0
+	for step := 0; ; step++ {
+		I := derivative(y, k[0])
+		maxInfected = math.Max(maxInfected, I)
+		if I < END_THRESHOLD || param.pastMaxTime(float64(step)*h) {
+			break
+		}
+		Ts = append(Ts, float64(step)*h)
+		Is = append(Is, I)
+		for stage, a := range []float64{h / 2, h / 2, h} {
+			for i := range next {
+				next[i] = y[i] + a*k[stage][i]
+			}
+			derivative(next, k[stage+1])
+		}
+		for i := range y {
+			y[i] += h / 6 * (k[0][i] + 2*k[1][i] + 2*k[2][i] + k[3][i])
+		}
+	}
+	return Ts, Is, maxInfected
+}
+
+// Checks that the parameters have an analytic solution: nothing may change
+// over the course of the epidemic.
+func (param Parameters) validateAnalytic() error {
+	if param.RunType != Analytic {
+		return nil
+	}
+	if param.Caution != nil || len(param.Interventions) > 0 || param.WaningRate > 0 || param.importing() {
+		return fmt.Errorf("%s runs don't support caution, interventions, waning immunity or importation", Analytic)
+	}
+	for _, v := range param.Vaccinations {
+		if v.Start > 0 || v.Duration > 0 {
+			return fmt.Errorf("%s runs only support pre-existing immunity, not vaccination campaigns", Analytic)
+		}
+	}
+	return nil
+}
+
+// Solves the differential equation's final size equation instead of
+// integrating it, which gives FinalR (everyone ever infected) to within
+// FINAL_SIZE_TOLERANCE of the population. Also records the
+// ReproductionNumber and GrowthRate, and, without a latent period or
+// infectious stages, MaxI, PeakTime and the other metrics of the course of
+// the epidemic. Fails if the parameters have no analytic solution, or if the
+// equations can't be solved numerically.
+func RunAnalytic(param Parameters) (RunSet, error) {
+	check := param
+	check.RunType = Analytic
+	if err := check.Validate(); err != nil {
+		return RunSet{}, err
+	}
+	m := newMeanField(param)
+	ever, err := m.finalSize()
+	if err != nil {
+		return RunSet{}, err
+	}
+	reproductionNumber, err := m.reproductionNumber()
+	if err != nil {
+		return RunSet{}, err
+	}
+	run := Run{
+		FinalR:             sum(ever),
+		ReproductionNumber: reproductionNumber,
+		GrowthRate:         growthRate(reproductionNumber, param),
+	}
+	if param.LatentLength == 0 && param.InfectiousStages <= 1 {
+		run.Ts, run.Is, run.MaxI = m.course(param)
+		// The course is saved at every step, of DT * DIFEQ_SAVE_STEPS.
+		interval := DT * DIFEQ_SAVE_STEPS
+		run.Duration = computeOutbreakDuration(run.Is, param, interval)
+		run.PeakTime = computePeakTime(run.Is, interval)
+		run.Waves = computeWaves(run.Is, param, interval)
+	}
+	return RunSet{Parameters: param, Runs: []Run{run}}, nil
+}
@@ -0,0 +1,125 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+// Without the hotspot everyone is alike: the reproduction number is R0 and
+// the final size and growth rate are the textbook ones.
+func TestAnalyticHomogeneous(t *testing.T) {
+	param := sirParameters()
+	param.RunType, param.R0, param.HotspotFraction, param.DiseaseLength = Analytic, 2, 0, 4
+	param.ComputeBetas()
+	if got, err := ReproductionNumber(param); err != nil || math.Abs(got-2) > 1e-9 {
+		t.Errorf("reproduction number %v, %v; want 2", got, err)
+	}
+	if got, err := GrowthRate(param); err != nil || math.Abs(got-0.25) > 1e-9 {
+		t.Errorf("growth rate %v, %v; want 0.25", got, err)
+	}
+	// z = 1 - (1 - i) exp(-R0 z), for an initially infected fraction i.
+	runSet, err := RunAnalytic(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := runSet.Runs[0].FinalR / float64(param.N)
+	i := INITIAL_INFECTEDS / float64(param.N)
+	if residual := z - 1 + (1-i)*math.Exp(-2*z); math.Abs(residual) > 1e-9 {
+		t.Errorf("final size %v is off the final size equation by %v", z, residual)
+	}
+}
+
+// The analytic final size, peak and growth rate are those the differential
+// equation gets to by integrating.
+func TestAnalyticMatchesDifEq(t *testing.T) {
+	communities := []Community{{Fraction: 1}, {Fraction: 1, RiskMean: 0.1}}
+	for _, test := range []struct {
+		name   string
+		change func(*Parameters)
+	}{
+		{"single hotspot", func(*Parameters) {}},
+		{"hotspots", func(p *Parameters) {
+			p.Hotspots = []Hotspot{{Weight: 1, Share: 1}, {Weight: 2, Share: 0.5, Visitors: Everyone}}
+		}},
+		{"communities", func(p *Parameters) { p.Communities = communities }},
+		{"infectiousness", func(p *Parameters) { p.AlphaDist = &AlphaDistribution{Shape: GammaAlpha, Mu: 1, Std: 2} }},
+		{"immunity", func(p *Parameters) { p.Vaccinations = []Vaccination{{Coverage: 0.2, Efficacy: 1, Target: HighestRisk}} }},
+	} {
+		param := sirParameters()
+		param.RunType, param.R0, param.RiskVariance, param.N = Analytic, 3, HighVar, 1000000
+		test.change(&param)
+		param.ComputeBetas()
+		integrated := param
+		integrated.RunType = DifEq
+		integrated.Integrator = RK45Integrator
+		integrated.Tolerance = 1e-9
+		want := RunDifEq(integrated).Runs[0]
+		runSet, err := RunAnalytic(param)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := runSet.Runs[0]
+		// The integration stops with up to END_THRESHOLD people infected,
+		// who have yet to recover and infect a few more.
+		if got.FinalR < want.FinalR || got.FinalR > want.FinalR+10*END_THRESHOLD {
+			t.Errorf("%s: FinalR %v; %v integrating", test.name, got.FinalR, want.FinalR)
+		}
+		if math.Abs(got.MaxI-want.MaxI) > 0.005*want.MaxI || math.Abs(got.PeakTime-want.PeakTime) > 0.2 {
+			t.Errorf("%s: peak of %v at %v; %v at %v integrating", test.name, got.MaxI, got.PeakTime, want.MaxI, want.PeakTime)
+		}
+
+		// Once the infected are spread over the classes like the infections
+		// they cause, and before many are immune, they grow at the growth
+		// rate.
+		from, to := 0, 0
+		for want.Is[to] < 1000 {
+			if want.Is[to] < 100 {
+				from = to
+			}
+			to++
+		}
+		if growth := math.Log(want.Is[to]/want.Is[from]) / (want.Ts[to] - want.Ts[from]); math.Abs(growth-got.GrowthRate) > 0.02*got.GrowthRate {
+			t.Errorf("%s: growth rate %v; %v integrating", test.name, got.GrowthRate, growth)
+		}
+	}
+}
+
+// The epidemic takes off above the threshold, where the growth rate turns
+// positive, and not below it, with a latent period too.
+func TestEpidemicThreshold(t *testing.T) {
+	param := sirParameters()
+	param.RunType, param.R0, param.RiskVariance = Analytic, 1, HighVar
+	param.LatentLength, param.LatentStages, param.InfectiousStages, param.DiseaseLength = 2, 2, 3, 3
+	param.ComputeBetas()
+	threshold, err := EpidemicThreshold(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold >= 1 {
+		t.Errorf("threshold R0 %v; want below 1, as the risk takers infect each other more", threshold)
+	}
+	for _, scale := range []float64{0.9, 1.1} {
+		p := param
+		p.R0 = threshold * scale
+		p.ComputeBetas()
+		runSet, err := RunAnalytic(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := runSet.Runs[0]
+		if takesOff := run.FinalR > 0.01*float64(p.N); takesOff != (scale > 1) || (run.GrowthRate > 0) != (scale > 1) {
+			t.Errorf("FinalR %v and growth rate %v at %v times the threshold", run.FinalR, run.GrowthRate, scale)
+		}
+		if math.Abs(run.ReproductionNumber-scale) > 1e-9 {
+			t.Errorf("reproduction number %v at %v times the threshold", run.ReproductionNumber, scale)
+		}
+	}
+
+	param.Caution = &Caution{Response: LinearResponse, Scale: 1}
+	if err := param.Validate(); err == nil {
+		t.Errorf("analytic run with caution passed validation")
+	}
+	if _, err := RunAnalytic(param); err == nil {
+		t.Errorf("ran an analytic run with caution")
+	}
+}
@@ -0,0 +1,102 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+)
+
+// Number of responsiveness classes each risk bucket of the differential and
+// difference equations is split into when responsiveness is heterogeneous.
+const RESPONSIVENESS_CLASSES = 10
+
+// How hotspot participation falls as the perceived prevalence x rises.
+type CautionResponse string
+
+const (
+	// Participation is scaled by max(0, 1 - Scale*x).
+	LinearResponse CautionResponse = "linear"
+	// Participation is scaled by 1 - Scale once x reaches Threshold.
+	ThresholdResponse CautionResponse = "threshold"
+	// Participation is scaled by exp(-Scale*x).
+	ExponentialResponse CautionResponse = "exponential"
+)
+
+// What people respond to.
+type CautionMeasure string
+
+const (
+	// The fraction of the population infected right now.
+	CurrentPrevalence CautionMeasure = ""
+	// The fraction of the population infected so far.
+	CumulativePrevalence CautionMeasure = "cumulative"
+)
+
+// Makes people less likely to go to the hotspot as the epidemic grows. An
+// individual with responsiveness k goes to the hotspot with probability
+// risk * response(k * x), where x is the prevalence they respond to.
+type Caution struct {
+	Response  CautionResponse
+	Measure   CautionMeasure `json:",omitempty"`
+	Scale     float64
+	Threshold float64 `json:",omitempty"`
+	// Responsiveness is gamma distributed with mean 1 and this variance; 0
+	// makes everyone equally responsive.
+	ResponsivenessVariance float64 `json:",omitempty"`
+}
+
+func (c Caution) Validate() error {
+	switch c.Response {
+	case LinearResponse, ExponentialResponse:
+	case ThresholdResponse:
+		if c.Scale > 1 {
+			return fmt.Errorf("threshold caution scale must be at most 1, got %v", c.Scale)
+		}
+	default:
+		return fmt.Errorf("unknown caution response %q", c.Response)
+	}
+	switch c.Measure {
+	case CurrentPrevalence, CumulativePrevalence:
+	default:
+		return fmt.Errorf("unknown caution measure %q", c.Measure)
+	}
+	if c.Scale < 0 || c.Threshold < 0 || c.ResponsivenessVariance < 0 {
+		return fmt.Errorf("caution scale, threshold and responsiveness variance must not be negative")
+	}
+	return nil
+}
+
+// The prevalence people respond to, given the numbers infected and
+// recovered out of n.
+func (c Caution) prevalence(infected float64, recovered float64, n float64) float64 {
+	if c.Measure == CumulativePrevalence {
+		return (infected + recovered) / n
+	}
+	return infected / n
+}
+
+// The factor hotspot participation is scaled by at perceived prevalence x.
+func (c Caution) response(x float64) float64 {
+	switch c.Response {
+	case LinearResponse:
+		return math.Max(0, 1-c.Scale*x)
+	case ThresholdResponse:
+		if x >= c.Threshold {
+			return 1 - c.Scale
+		}
+		return 1
+	case ExponentialResponse:
+		return math.Exp(-c.Scale * x)
+	}
+	return 1
+}
+
+// Splits the responsiveness distribution into RESPONSIVENESS_CLASSES classes
+// of equal probability, each represented by its mean; a single class of
+// responsiveness 1 if it is homogeneous.
+func (c Caution) responsivenessClasses() []float64 {
+	if c.ResponsivenessVariance == 0 {
+		return []float64{1}
+	}
+	dist, sizeBiased := gammaWithMean(1, c.ResponsivenessVariance, nil)
+	return sliceMeans(dist, sizeBiased, RESPONSIVENESS_CLASSES)
+}
@@ -0,0 +1,97 @@
+package simulate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCautionResponse(t *testing.T) {
+	for _, test := range []struct {
+		caution Caution
+		x       float64
+		want    float64
+	}{
+		{Caution{Response: LinearResponse, Scale: 2}, 0.25, 0.5},
+		{Caution{Response: LinearResponse, Scale: 2}, 0.75, 0},
+		{Caution{Response: ThresholdResponse, Scale: 0.8, Threshold: 0.1}, 0.05, 1},
+		{Caution{Response: ThresholdResponse, Scale: 0.8, Threshold: 0.1}, 0.1, 0.2},
+		{Caution{Response: ExponentialResponse, Scale: 10}, 0.1, math.Exp(-1)},
+	} {
+		if got := test.caution.response(test.x); math.Abs(got-test.want) > tolerance {
+			t.Errorf("%+v: response(%v) = %v; want %v", test.caution, test.x, got, test.want)
+		}
+	}
+
+	cumulative := Caution{Measure: CumulativePrevalence}
+	if got := cumulative.prevalence(10, 30, 100); got != 0.4 {
+		t.Errorf("cumulative prevalence %v; want 0.4", got)
+	}
+}
+
+func TestResponsivenessClasses(t *testing.T) {
+	caution := Caution{Response: LinearResponse, ResponsivenessVariance: 0.5}
+	levels := caution.responsivenessClasses()
+	if len(levels) != RESPONSIVENESS_CLASSES {
+		t.Fatalf("%v responsiveness classes; want %v", len(levels), RESPONSIVENESS_CLASSES)
+	}
+	mean := 0.0
+	for k, level := range levels {
+		if k > 0 && level <= levels[k-1] {
+			t.Fatalf("responsiveness classes %v aren't increasing", levels)
+		}
+		mean += level / float64(len(levels))
+	}
+	if math.Abs(mean-1) > 0.05 {
+		t.Fatalf("mean responsiveness %v; want about 1", mean)
+	}
+
+	param := defaultParameters
+	param.Caution = &caution
+	_, S, I, _ := initializeClasses(param)
+	if len(S) != BUCKETS*RESPONSIVENESS_CLASSES || math.Abs(sum(S)+sum(I)-N) > tolerance {
+		t.Fatalf("%v classes holding %v people", len(S), sum(S)+sum(I))
+	}
+}
+
+// A response that never changes anything leaves every model as it was.
+func TestCautionNoResponse(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction, param.Trials, param.Seed, param.Record = 0.75, 20, 5, RecordNone
+	param.ComputeBetas()
+	indifferent := param
+	indifferent.Caution = &Caution{Response: LinearResponse, Scale: 0}
+	for _, run := range []func(Parameters) RunSet{RunSimulation, RunDifEq, runDifference} {
+		if !reflect.DeepEqual(run(indifferent).Runs, run(param).Runs) {
+			t.Fatalf("caution without a response changed the results")
+		}
+	}
+}
+
+// Caution makes outbreaks smaller in every model, whether or not people
+// differ in how much they respond.
+func TestCautionShrinksOutbreaks(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction, param.Trials, param.Seed, param.Record = 0.75, 20, 5, RecordNone
+	param.ComputeBetas()
+	meanFinalR := func(runSet RunSet) float64 {
+		total := 0.0
+		for _, run := range runSet.Runs {
+			total += run.FinalR
+		}
+		return total / float64(len(runSet.Runs))
+	}
+	for _, variance := range []float64{0, 1} {
+		cautious := param
+		cautious.Caution = &Caution{Response: ExponentialResponse, Scale: 20, ResponsivenessVariance: variance}
+		for runType, run := range map[RunType]func(Parameters) RunSet{
+			Simulation: RunSimulation, DifEq: RunDifEq, Difference: runDifference,
+		} {
+			without, with := meanFinalR(run(param)), meanFinalR(run(cautious))
+			if with >= without {
+				t.Errorf("%v, responsiveness variance %v: FinalR %v with caution; %v without",
+					runType, variance, with, without)
+			}
+		}
+	}
+}
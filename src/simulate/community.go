@@ -0,0 +1,182 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// One of several communities, or sub-populations, that make up a
+// metapopulation. Community transmission only happens within a community,
+// so communities are only linked by the hotspots they share.
+type Community struct {
+	// Share of the N individuals living in the community, relative to the
+	// other communities.
+	Fraction float64
+	// The community part of R0 within the community;
+	// R0 * (1 - HotspotFraction) if nil.
+	R0 *float64 `json:",omitempty"`
+	// The community's risk tolerance distribution; the point's if RiskMean
+	// is 0.
+	RiskMean     float64      `json:",omitempty"`
+	RiskVariance RiskVariance `json:",omitempty"`
+
+	// Filled in by ComputeBetas:
+	N        int
+	BetaC    float64
+	RiskDist *RiskDistribution
+}
+
+func (c Community) Validate() error {
+	if c.Fraction <= 0 {
+		return fmt.Errorf("community fraction must be positive, got %v", c.Fraction)
+	}
+	if c.R0 != nil && *c.R0 < 0 {
+		return fmt.Errorf("community R0 must not be negative, got %v", *c.R0)
+	}
+	if c.RiskMean < 0 || c.RiskMean >= 1 {
+		return fmt.Errorf("community risk mean must be in [0, 1), got %v", c.RiskMean)
+	}
+	switch c.RiskVariance {
+	case "", LowVar, MediumVar, HighVar:
+	default:
+		return fmt.Errorf("unknown risk variance %q", c.RiskVariance)
+	}
+	return nil
+}
+
+// Whether hotspot h draws visitors from community c.
+func (h Hotspot) drawsFrom(c int) bool {
+	if len(h.Communities) == 0 {
+		return true
+	}
+	for _, community := range h.Communities {
+		if community == c {
+			return true
+		}
+	}
+	return false
+}
+
+// The communities of a run: Communities, or a single one with everybody in
+// it.
+func (param Parameters) communities() []Community {
+	if len(param.Communities) > 0 {
+		return param.Communities
+	}
+	return []Community{{Fraction: 1, N: param.N, BetaC: param.BetaC, RiskDist: param.RiskDist}}
+}
+
+// Splits N between the communities, fills in their risk distributions and
+// BetaC, and the BetaR of each hotspot from the people it draws from, the
+// way ComputeBetas does for a single population. The single hotspot becomes
+// one that draws from every community. Communities and hotspots are copied,
+// since experiment points share them.
+func (param *Parameters) computeCommunityBetas(gamma float64) {
+	total := 0.0
+	for _, c := range param.Communities {
+		total += c.Fraction
+	}
+	communities := make([]Community, len(param.Communities))
+	remaining := param.N
+	for i, c := range param.Communities {
+		c.N = int(c.Fraction / total * float64(param.N))
+		if i == len(communities)-1 {
+			c.N = remaining
+		}
+		remaining -= c.N
+		if c.RiskMean == 0 {
+			c.RiskMean = param.RiskMean
+		}
+		if c.RiskVariance == "" {
+			c.RiskVariance = param.RiskVariance
+		}
+		c.RiskDist = RiskDist(c.RiskMean, c.RiskVariance)
+		R0 := param.R0 * (1 - param.HotspotFraction)
+		if c.R0 != nil {
+			R0 = *c.R0
+		}
+		c.BetaC = 0
+		if c.N > 0 {
+			c.BetaC = gamma * R0 / float64(c.N)
+		}
+		communities[i] = c
+	}
+	param.Communities = communities
+
+	if len(param.Hotspots) == 0 {
+		param.Hotspots = []Hotspot{{Weight: 1, Share: 1}}
+	}
+	weights := 0.0
+	for _, h := range param.Hotspots {
+		weights += h.Weight
+	}
+	hotspots := make([]Hotspot, len(param.Hotspots))
+	for k, h := range param.Hotspots {
+		// The number of people the hotspot draws from, and their mean
+		// visitation probability.
+		n, visits := 0.0, 0.0
+		for i, c := range communities {
+			if h.drawsFrom(i) {
+				n += float64(c.N)
+				visits += float64(c.N) * h.visitation(c.RiskMean)
+			}
+		}
+		h.BetaR = 0
+		if weights > 0 && visits > 0 {
+			mean := visits / n
+			h.BetaR = gamma * (param.R0 * param.HotspotFraction * h.Weight / weights / mean / mean) / n
+		}
+		hotspots[k] = h
+	}
+	param.Hotspots = hotspots
+	param.BetaC, param.BetaR = 0, 0
+}
+
+func validateCommunities(param Parameters) error {
+	for _, c := range param.Communities {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, h := range param.Hotspots {
+		for _, c := range h.Communities {
+			if c < 0 || c >= len(param.Communities) {
+				return fmt.Errorf("hotspot draws from community %d, but there are %d communities", c, len(param.Communities))
+			}
+		}
+	}
+	if len(param.Communities) == 0 {
+		return nil
+	}
+	if param.RunType == Difference {
+		return errors.New("difference runs don't support communities")
+	}
+	if param.Network != nil {
+		return errors.New("communities can't be combined with a contact network")
+	}
+	return nil
+}
+
+// Sums weights[c] * population[c] over the classes of each community into
+// totals, like chainWeightedSum does over all of them; nil weights count
+// everyone once.
+func communitySums(chain [][]float64, weights []float64, community []int, totals []float64) {
+	for i := range totals {
+		totals[i] = 0
+	}
+	partial := make([]float64, len(totals))
+	for _, stage := range chain {
+		for i := range partial {
+			partial[i] = 0
+		}
+		for c, p := range stage {
+			if weights != nil {
+				p *= weights[c]
+			}
+			partial[community[c]] += p
+		}
+		for i := range totals {
+			totals[i] += partial[i]
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeCommunityBetas(t *testing.T) {
+	R0 := 1.0
+	communities := []Community{{Fraction: 1}, {Fraction: 3, R0: &R0, RiskMean: 0.5}}
+	param := sirParameters()
+	param.N, param.Communities = 2000, communities
+	param.ComputeBetas()
+	if communities[0].N != 0 || param.BetaC != 0 || param.BetaR != 0 {
+		t.Fatalf("ComputeBetas changed the shared communities or set BetaC and BetaR")
+	}
+	first, second := param.Communities[0], param.Communities[1]
+	if first.N != 500 || second.N != 1500 {
+		t.Errorf("communities of %v and %v; want 500 and 1500", first.N, second.N)
+	}
+	if want := param.R0 * (1 - param.HotspotFraction) / 500; math.Abs(first.BetaC-want) > 1e-12 {
+		t.Errorf("first community has BetaC %v; want %v", first.BetaC, want)
+	}
+	if want := R0 / 1500; math.Abs(second.BetaC-want) > 1e-12 {
+		t.Errorf("second community has BetaC %v; want %v", second.BetaC, want)
+	}
+	if *second.RiskDist != *RiskDist(0.5, param.RiskVariance) {
+		t.Errorf("second community has risk distribution %+v", *second.RiskDist)
+	}
+	// The single hotspot draws from everyone, with mean risk 0.4375.
+	if len(param.Hotspots) != 1 {
+		t.Fatalf("hotspots %+v; want one", param.Hotspots)
+	}
+	if want := param.R0 * param.HotspotFraction / 0.4375 / 0.4375 / 2000; math.Abs(param.Hotspots[0].BetaR-want) > 1e-12 {
+		t.Errorf("hotspot has BetaR %v; want %v", param.Hotspots[0].BetaR, want)
+	}
+
+	empty, unknown := sirParameters(), sirParameters()
+	empty.Communities = []Community{{Fraction: 0}}
+	unknown.Communities = []Community{{Fraction: 1}}
+	unknown.Hotspots = []Hotspot{{Weight: 1, Share: 1, Communities: []int{1}}}
+	for _, param := range []Parameters{empty, unknown, sirParameters()} {
+		if param.RunType == "" {
+			param.Communities = []Community{{Fraction: 1}}
+			param.RunType = Difference
+		}
+		if err := param.Validate(); err == nil {
+			t.Errorf("%+v passed validation", param)
+		}
+	}
+}
+
+// A single community is the same as none.
+func TestSingleCommunity(t *testing.T) {
+	single := sirParameters()
+	param := single
+	param.Communities = []Community{{Fraction: 1}}
+	param.ComputeBetas()
+	got, want := RunDifEq(param).Runs[0], RunDifEq(single).Runs[0]
+	if math.Abs(got.FinalR-want.FinalR) > 1e-9*want.FinalR || got.CommunityFinalRs[0] != got.FinalR {
+		t.Errorf("FinalR %v (%v in the community) with a single community; want %v",
+			got.FinalR, got.CommunityFinalRs, want.FinalR)
+	}
+}
+
+// Communities only infect each other through the hotspots they share.
+func TestCommunitiesShareHotspots(t *testing.T) {
+	communities := []Community{{Fraction: 1}, {Fraction: 1}}
+	for _, test := range []struct {
+		name     string
+		hotspots []Hotspot
+		spreads  bool
+	}{
+		{"shared", nil, true},
+		{"separate", []Hotspot{{Weight: 1, Share: 1, Communities: []int{0}},
+			{Weight: 1, Share: 1, Communities: []int{1}}}, false},
+	} {
+		param := sirParameters()
+		param.N, param.Trials = 2000, 20
+		param.Communities, param.Hotspots = communities, test.hotspots
+		param.ComputeBetas()
+		runs := append(RunDifEq(param).Runs, RunSimulation(param).Runs...)
+		reached := 0
+		for _, run := range runs {
+			if math.Abs(run.CommunityFinalRs[0]+run.CommunityFinalRs[1]-run.FinalR) > 1e-9*run.FinalR {
+				t.Fatalf("%s: community FinalRs %v don't add up to %v", test.name, run.CommunityFinalRs, run.FinalR)
+			}
+			if run.CommunityFinalRs[1] > 1 {
+				reached++
+			}
+		}
+		if spread := reached > len(runs)/2; spread != test.spreads {
+			t.Errorf("%s hotspots: %v of %v runs reached the second community", test.name, reached, len(runs))
+		}
+	}
+}
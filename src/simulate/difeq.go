@@ -2,8 +2,6 @@ package simulate
 
 import (
 	"math"
-
-	"gonum.org/v1/gonum/stat/distuv"
 )
 
 // Generic differential equation parameters:
@@ -12,6 +10,9 @@ const INITIAL_INFECTEDS = 1.0
 const BUCKETS = 100
 const END_THRESHOLD = 0.1
 
+// The differential equation saves its state every this many steps.
+const DIFEQ_SAVE_STEPS = 10
+
 func sum(population []float64) float64 {
 	total := 0.0
 	for _, p := range population {
@@ -20,186 +21,672 @@ func sum(population []float64) float64 {
 	return total
 }
 
-func firstMoment(population []float64, buckets int) float64 {
-	moment := 0.0
-	for b := 0; b < buckets; b++ {
-		moment += riskValue(b, buckets) * population[b]
-	}
-	return moment
-}
-
 func riskValue(b int, buckets int) float64 {
 	return (float64(b) + 0.5) / float64(buckets)
 }
 
 func InitializePopulations(param Parameters) ([]float64, []float64, []float64) {
+	_, mass := param.riskBuckets(param.RiskDist)
+	return bucketPopulations(param.N, mass, INITIAL_INFECTEDS)
+}
+
+// Splits n people into risk buckets with the given shares of the population,
+// with a total of infected of them infected.
+func bucketPopulations(n int, mass []float64, infected float64) ([]float64, []float64, []float64) {
+	S := make([]float64, len(mass))
+	I := make([]float64, len(mass))
+	R := make([]float64, len(mass))
 
-	A, B := 1.0, 1.0
-	if param.RiskDist != nil {
-		A, B = param.RiskDist.A, param.RiskDist.B
+	// initialize the susceptible population from the shares
+	for b := range mass {
+		S[b] = float64(n) * mass[b]
 	}
-	beta := distuv.Beta{Alpha: A, Beta: B, Src: nil}
+	// move a total of infected from S to I
+	for b := range mass {
+		I[b] = S[b] * (infected / float64(n))
+		S[b] = S[b] * (1 - (infected / float64(n)))
+	}
+	return S, I, R
+}
 
-	S := make([]float64, BUCKETS)
-	I := make([]float64, BUCKETS)
-	R := make([]float64, BUCKETS)
+// The differential and difference equations follow the population in classes
+// of individuals that behave alike: each community is split into risk
+// buckets, each risk bucket into one class per responsiveness level, and each
+// of those into one class per infectiousness level. Class c has risk risk[c],
+// and so on.
+type classes struct {
+	risk           []float64
+	responsiveness []float64
+	infectiousness []float64
+	community      []int
+	caution        *Caution
+	// The order classes are seeded in, if the seeding isn't random.
+	seeds []int
+}
 
-	// initialize the susceptible population using the CDF
-	for b := 0; b < BUCKETS; b++ {
-		// each bucket should have this much mass in it cdf(x+1) - cdf(x)
-		cumulative := beta.CDF(float64(b+1)/BUCKETS) - beta.CDF(float64(b)/BUCKETS)
-		S[b] = float64(param.N) * cumulative
+// Splits the populations of InitializePopulations, or of each community,
+// into classes, with the risk buckets of the Discretisation. Risk, responsiveness and infectiousness are independent.
+func initializeClasses(param Parameters) (classes, []float64, []float64, []float64) {
+	communities := param.communities()
+	responsiveness := []float64{1}
+	if param.Caution != nil {
+		responsiveness = param.Caution.responsivenessClasses()
 	}
-	// move a total of INITIAL_INFECTEDS from S to I
-	for b := 0; b < BUCKETS; b++ {
-		I[b] = S[b] * (INITIAL_INFECTEDS / float64(param.N))
-		S[b] = S[b] * (1 - (INITIAL_INFECTEDS / float64(param.N)))
+	infectiousness, weights := []float64{1}, []float64{1}
+	if param.AlphaDist != nil {
+		infectiousness, weights = param.AlphaDist.classes()
 	}
-	return S, I, R
+
+	n := len(communities) * param.buckets() * len(responsiveness) * len(infectiousness)
+	c := classes{
+		risk:           make([]float64, 0, n),
+		responsiveness: make([]float64, 0, n),
+		infectiousness: make([]float64, 0, n),
+		community:      make([]int, 0, n),
+		caution:        param.Caution,
+	}
+	S, I, R := make([]float64, 0, n), make([]float64, 0, n), make([]float64, 0, n)
+	for i, community := range communities {
+		// The initial infections are all in the first community, unless
+		// they are seeded below.
+		infected := 0.0
+		if i == 0 && param.Seeding == nil {
+			infected = INITIAL_INFECTEDS
+		}
+		risk, mass := param.riskBuckets(community.RiskDist)
+		bucketS, bucketI, bucketR := bucketPopulations(community.N, mass, infected)
+		for b := range risk {
+			for _, k := range responsiveness {
+				for l, alpha := range infectiousness {
+					share := weights[l] / float64(len(responsiveness))
+					c.risk = append(c.risk, risk[b])
+					c.responsiveness = append(c.responsiveness, k)
+					c.infectiousness = append(c.infectiousness, alpha)
+					c.community = append(c.community, i)
+					S = append(S, bucketS[b]*share)
+					I = append(I, bucketI[b]*share)
+					R = append(R, bucketR[b]*share)
+				}
+			}
+		}
+	}
+	if param.Seeding != nil {
+		c.seeds = c.seedOrder(*param.Seeding, S)
+		c.seed(S, float64(param.Seeding.Count), I)
+		for i := range S {
+			S[i] -= I[i]
+		}
+	}
+	return c, S, I, R
+}
+
+// Fills in the probability that each class goes to each hotspot, q[k][c],
+// when the infected and recovered totals are sumI and sumR.
+func (c classes) participation(q [][]float64, hotspots []Hotspot, sumI float64, sumR float64, n float64) {
+	x := 0.0
+	if c.caution != nil {
+		x = c.caution.prevalence(sumI, sumR, n)
+	}
+	for k, h := range hotspots {
+		for i, risk := range c.risk {
+			if !h.drawsFrom(c.community[i]) {
+				q[k][i] = 0
+				continue
+			}
+			q[k][i] = h.visitation(risk)
+			if c.caution != nil {
+				q[k][i] *= c.caution.response(c.responsiveness[i] * x)
+			}
+		}
+	}
+}
+
+// Sums weights[c] * population[c] over the classes.
+func weightedSum(population []float64, weights []float64) float64 {
+	total := 0.0
+	for c, p := range population {
+		total += weights[c] * p
+	}
+	return total
+}
+
+// Sums weights[c] * alphas[c] * population[c] over the classes.
+func weightedSum2(population []float64, weights []float64, alphas []float64) float64 {
+	total := 0.0
+	for c, p := range population {
+		total += weights[c] * p * alphas[c]
+	}
+	return total
+}
+
+// Sums weightedSum over every stage of a chain.
+func chainWeightedSum(chain [][]float64, weights []float64) float64 {
+	total := 0.0
+	for _, stage := range chain {
+		total += weightedSum(stage, weights)
+	}
+	return total
+}
+
+// Sums weightedSum2 over every stage of a chain.
+func chainWeightedSum2(chain [][]float64, weights []float64, alphas []float64) float64 {
+	total := 0.0
+	for _, stage := range chain {
+		total += weightedSum2(stage, weights, alphas)
+	}
+	return total
+}
+
+// The latent (E) and infectious (I) stages of a mean-field model, with the
+// initially infected people just infected: exposed if there is a latent
+// period, infectious otherwise.
+func initializeChains(initial []float64, latent stages, infectious stages) ([][]float64, [][]float64) {
+	E, I := newChain(latent.n, len(initial)), newChain(infectious.n, len(initial))
+	if latent.n > 0 {
+		copy(E[0], initial)
+	} else {
+		copy(I[0], initial)
+	}
+	return E, I
+}
+
+// The differential equation as a system dy/dt = f(y), for the integrators.
+// The state y holds S, each latent stage, each infectious stage, R and the
+// unvaccinated susceptible (see infectSusceptible), each of them class by
+// class; then everyone who has lost their immunity in each community, so that
+// adding them to R gives everyone who has ever recovered; then everyone
+// imported. BetaC and BetaR are fixed over a step.
+type difEqSystem struct {
+	param              Parameters
+	cls                classes
+	hotspots           []Hotspot
+	communities        []Community
+	latent, infectious stages
+	// BetaC in each community and BetaR at each hotspot over the step.
+	alphaC, alphaR []float64
+	// The participation at each hotspot, and the infectious contacts in each
+	// community and at each hotspot, in the state last passed to contacts.
+	q                   [][]float64
+	communityI, momentI []float64
+	// Scratch space, one entry per class.
+	ones, newInfections, becomingInfectious, recovering []float64
+}
+
+// A view of the compartments in a state of the system.
+type difEqState struct {
+	S, R, unvaccinated []float64
+	E, I               [][]float64
+	waned              []float64
+	imported           []float64
+}
+
+func newDifEqSystem(param Parameters, cls classes) *difEqSystem {
+	numClasses := len(cls.risk)
+	hotspots := param.hotspots()
+	communities := param.communities()
+	ones := make([]float64, numClasses)
+	for c := range ones {
+		ones[c] = 1
+	}
+	// The latent and infectious periods are chains of exponential stages;
+	// with a single infectious stage its rate, gamma, is the inverse of the
+	// disease length.
+	return &difEqSystem{
+		param:              param,
+		cls:                cls,
+		hotspots:           hotspots,
+		communities:        communities,
+		latent:             continuousStages(param.LatentLength, param.LatentStages),
+		infectious:         continuousStages(param.DiseaseLength, param.InfectiousStages),
+		q:                  newChain(len(hotspots), numClasses),
+		communityI:         make([]float64, len(communities)),
+		momentI:            make([]float64, len(hotspots)),
+		ones:               ones,
+		newInfections:      make([]float64, numClasses),
+		becomingInfectious: make([]float64, numClasses),
+		recovering:         make([]float64, numClasses),
+	}
+}
+
+// The number of entries in a state.
+func (sys *difEqSystem) size() int {
+	return len(sys.cls.risk)*(sys.latent.n+sys.infectious.n+3) + len(sys.communities) + 1
+}
+
+func (sys *difEqSystem) state(y []float64) difEqState {
+	numClasses := len(sys.cls.risk)
+	next := func(n int) []float64 {
+		part := y[:n:n]
+		y = y[n:]
+		return part
+	}
+	st := difEqState{S: next(numClasses)}
+	st.E = make([][]float64, sys.latent.n)
+	for j := range st.E {
+		st.E[j] = next(numClasses)
+	}
+	st.I = make([][]float64, sys.infectious.n)
+	for j := range st.I {
+		st.I[j] = next(numClasses)
+	}
+	st.R = next(numClasses)
+	st.unvaccinated = next(numClasses)
+	st.waned = next(len(sys.communities))
+	st.imported = next(1)
+	return st
+}
+
+// Works out the participation at each hotspot, which is just the risk at a
+// single hotspot unless people are cautious, and the infectious contacts in
+// each community and at each hotspot. The infected count as their
+// infectiousness.
+func (sys *difEqSystem) contacts(st difEqState) {
+	sumI := chainSum(st.I)
+	sys.cls.participation(sys.q, sys.hotspots, sumI, sum(st.R)+sum(st.waned), float64(sys.param.N))
+	communitySums(st.I, sys.cls.infectiousness, sys.cls.community, sys.communityI)
+	for k := range sys.hotspots {
+		sys.momentI[k] = chainWeightedSum2(st.I, sys.q[k], sys.cls.infectiousness)
+	}
+}
+
+// The new infections per unit time in each class with S susceptible, given
+// the contacts. Returns those in the communities and at the hotspots.
+func (sys *difEqSystem) infections(S []float64, newInfections []float64) (float64, float64) {
+	var communityInfections, riskyInfections float64
+	for c := range S {
+		community := S[c] * sys.alphaC[sys.cls.community[c]] * sys.communityI[sys.cls.community[c]]
+		risky := 0.0
+		for k := range sys.hotspots {
+			risky += S[c] * sys.q[k][c] * sys.alphaR[k] * sys.momentI[k]
+		}
+		newInfections[c] = community + risky
+		communityInfections += community
+		riskyInfections += risky
+	}
+	return communityInfections, riskyInfections
+}
+
+// Sets dydt to the rate of change of the state y.
+func (sys *difEqSystem) derivative(y []float64, dydt []float64) {
+	for i := range dydt {
+		dydt[i] = 0
+	}
+	st, d := sys.state(y), sys.state(dydt)
+	sys.contacts(st)
+	sys.infections(st.S, sys.newInfections)
+	if sys.param.importing() {
+		d.imported[0] = -sum(sys.newInfections)
+		sys.cls.seed(st.S, sys.param.Seeding.ImportationRate, sys.newInfections)
+		d.imported[0] += sum(sys.newInfections)
+	}
+	for c := range st.S {
+		d.S[c] = -sys.newInfections[c]
+		d.unvaccinated[c] = -sys.newInfections[c] * ratio(st.unvaccinated[c], st.S[c])
+		sys.becomingInfectious[c] = 0
+	}
+	// The newly infected become exposed (or infectious, if there is no
+	// latent period), the exposed infectious and the infectious recover.
+	flow(st.E, sys.newInfections, d.E, sys.becomingInfectious, sys.latent)
+	flow(st.I, sys.becomingInfectious, d.I, d.R, sys.infectious)
+	if sys.param.WaningRate > 0 {
+		for c := range st.R {
+			waning := st.R[c] * sys.param.WaningRate
+			d.R[c] -= waning
+			d.S[c] += waning
+			d.unvaccinated[c] += waning
+			d.waned[sys.cls.community[c]] += waning
+		}
+	}
+}
+
+// Advances the state y by a step of length dt of linearly implicit Euler:
+// everyone leaves their compartment at the rate its size at the end of the
+// step gives, with the force of infection at the start.
+func (sys *difEqSystem) implicitStep(y []float64, dt float64) {
+	st := sys.state(y)
+	sys.contacts(st)
+	// The force of infection on each class.
+	force := make([]float64, len(st.S))
+	sys.infections(sys.ones, force)
+	for c := range st.S {
+		sys.newInfections[c] = 0
+		sys.becomingInfectious[c] = 0
+		sys.recovering[c] = 0
+	}
+	if sys.param.importing() {
+		sys.cls.seed(st.S, sys.param.Seeding.ImportationRate*dt, sys.newInfections)
+		st.imported[0] += sum(sys.newInfections)
+	}
+	for c := range st.S {
+		remaining := st.S[c] - sys.newInfections[c]
+		sys.newInfections[c] += remaining * force[c] * dt / (1 + force[c]*dt)
+	}
+	infectSusceptible(st.S, st.unvaccinated, sys.newInfections)
+	settle(st.E, sys.newInfections, sys.becomingInfectious, sys.latent, dt)
+	settle(st.I, sys.becomingInfectious, sys.recovering, sys.infectious, dt)
+	for c := range st.R {
+		before := st.R[c] + sys.recovering[c]
+		st.R[c] = before / (1 + sys.param.WaningRate*dt)
+		waning := before - st.R[c]
+		st.S[c] += waning
+		st.unvaccinated[c] += waning
+		st.waned[sys.cls.community[c]] += waning
+	}
+}
+
+// The system and its initial state, with everyone in each class at the
+// start.
+func initialDifEq(param Parameters) (*difEqSystem, []float64, []float64) {
+	cls, S, initial, R := initializeClasses(param)
+	sys := newDifEqSystem(param, cls)
+	y := make([]float64, sys.size())
+	st := sys.state(y)
+	copy(st.S, S)
+	copy(st.R, R)
+	copy(st.unvaccinated, S)
+	E, I := initializeChains(initial, sys.latent, sys.infectious)
+	for j := range E {
+		copy(st.E[j], E[j])
+	}
+	for j := range I {
+		copy(st.I[j], I[j])
+	}
+	return sys, y, initialPopulation(S, initial)
 }
 
 func RunDifEq(param Parameters) RunSet {
 
-	S, I, R := InitializePopulations(param)
-	// Gamma in the dif eq is the inverse of disease length:
-	gamma := 1 / float64(param.DiseaseLength)
+	sys, y, population := initialDifEq(param)
+	cls := sys.cls
+	st := sys.state(y)
+	S, E, I, R := st.S, st.E, st.I, st.R
+	integrate, step := param.newIntegrator(len(y))
+
+	communities, hotspots := sys.communities, sys.hotspots
+	// The susceptible in each community.
+	communityS := make([]float64, len(communities))
 	Ts := []float64{}
+	Es := []float64{}
 	Is := []float64{}
 	Rs := []float64{}
 	Rts := []float64{}
 	EffectiveBetas := []float64{}
 	IRisks, SRisks := []float64{}, []float64{}
+	RiskyInfections, CommunityInfections := []float64{}, []float64{}
+	var CommunityIs [][]float64
+	if len(param.Communities) > 0 {
+		CommunityIs = make([][]float64, len(communities))
+	}
 
+	schedule := newInterventionSchedule(param.Interventions)
 	maxInfected := -1.0
 	currentTime := 0.0
-
-	for sumI := sum(I); sumI >= END_THRESHOLD; sumI = sum(I) {
+	truncated := false
+	campaigns := cls.campaigns(param.Vaccinations, population)
+	vaccinated := 0.0
+	// The state is saved every interval, and steps end on the saves.
+	interval := DT * DIFEQ_SAVE_STEPS
+	saves := 0
+
+	for sumI, sumE := chainSum(I), chainSum(E); sumI+sumE >= END_THRESHOLD || param.importing(); sumI, sumE = chainSum(I), chainSum(E) {
+		if param.pastMaxTime(currentTime) {
+			truncated = true
+			break
+		}
+		saveData := currentTime >= float64(saves)*interval-interval*1e-6
+		if saveData {
+			saves++
+		}
+		h := math.Min(step, float64(saves)*interval-currentTime)
+		if campaigns != nil {
+			vaccinated += vaccinateClasses(campaigns, currentTime, h, param.N, S, st.unvaccinated)
+		}
 
 		if sumI > maxInfected {
 			maxInfected = sumI
 		}
-		// Need to compute these two updates:
-		recoveries := make([]float64, BUCKETS)
-		newInfections := make([]float64, BUCKETS)
-
-		// Recoveries are straight forward
-		for b := 0; b < BUCKETS; b++ {
-			recoveries[b] = I[b] * gamma * DT
-		}
-
-		// sumI = sum(I)
-		var rInfectionsThisGen, cInfectionsThisGen float64
-		momentI := firstMoment(I, BUCKETS)
-
-		// apply interventions like this:
-		var alphaR float64 = param.BetaR
-		for b := 0; b < BUCKETS; b++ {
-			communityInfections := S[b] * param.BetaC * sumI * DT
-			// if interventionInEffect {
-			// 	communityInfections *= (1.0 / 2.0)
-			// }
-
-			riskyInfections := S[b] * riskValue(b, BUCKETS) * alphaR * momentI * DT
+		// alphaC and alphaR include any interventions, and q any caution
+		sys.alphaC, sys.alphaR = schedule.rates(currentTime, sumI/float64(param.N), communities, hotspots)
 
-			newInfections[b] = communityInfections + riskyInfections
-
-			// Save these to return per generation
-			cInfectionsThisGen += communityInfections
-			rInfectionsThisGen += riskyInfections
-		}
-		saveData := int(currentTime/DT)%10 == 0
 		// Compute Rt
 		if saveData {
+			sys.contacts(st)
+			cInfections, rInfections := sys.infections(S, sys.newInfections)
 			sumS := sum(S)
-			momentS := firstMoment(S, BUCKETS)
-			// alphaR is the version that possibly uses 'caution' and interventions
-			Rt := sumS * (param.BetaC + alphaR*(momentI/sumI)*(momentS/sumS))
-			Rts = append(Rts, Rt)
-
-			EffectiveBeta := (param.BetaC + alphaR*(momentI/sumI)*(momentS/sumS))
+			communitySums([][]float64{S}, nil, cls.community, communityS)
+			// (nobody may be infectious yet during a latent period)
+			EffectiveBeta := 0.0
+			for i := range communities {
+				EffectiveBeta += sys.alphaC[i] * ratio(sys.communityI[i], sumI) * (communityS[i] / sumS)
+			}
+			for k := range hotspots {
+				momentS := weightedSum(S, sys.q[k])
+				EffectiveBeta += sys.alphaR[k] * ratio(sys.momentI[k], sumI) * (momentS / sumS)
+			}
+			Rts = append(Rts, sumS*EffectiveBeta)
 			EffectiveBetas = append(EffectiveBetas, EffectiveBeta)
 
-			IRisks = append(IRisks, (momentI / sumI))
-			SRisks = append(SRisks, (momentS / sumS))
+			IRisks = append(IRisks, ratio(chainWeightedSum(I, cls.risk), sumI))
+			SRisks = append(SRisks, weightedSum(S, cls.risk)/sumS)
+			if sys.latent.n > 0 {
+				Es = append(Es, sumE)
+			}
 			Is = append(Is, sumI)
 			Rs = append(Rs, sum(R))
-			Ts = append(Ts, currentTime)
+			Ts = append(Ts, float64(saves-1)*interval)
+			if CommunityIs != nil {
+				sick := make([]float64, len(communities))
+				communitySums(I, nil, cls.community, sick)
+				for i := range CommunityIs {
+					CommunityIs[i] = append(CommunityIs[i], sick[i])
+				}
+			}
+			// As rates per unit time, to compare with the simulation's daily counts.
+			RiskyInfections = append(RiskyInfections, rInfections)
+			CommunityInfections = append(CommunityInfections, cInfections)
+		}
+
+		taken, next := integrate.step(sys, y, h)
+		if taken == h && h < step {
+			// Only cut short to end on a save.
+			next = math.Max(next, step)
 		}
+		step = next
+		currentTime += taken
+	}
 
-		for b := 0; b < BUCKETS; b++ {
-			S[b] -= newInfections[b]
-			I[b] += newInfections[b]
-			I[b] -= recoveries[b]
-			R[b] += recoveries[b]
+	var CommunityFinalRs []float64
+	if len(param.Communities) > 0 {
+		CommunityFinalRs = make([]float64, len(communities))
+		communitySums([][]float64{R}, nil, cls.community, CommunityFinalRs)
+		for i := range CommunityFinalRs {
+			CommunityFinalRs[i] += st.waned[i]
 		}
-		currentTime += DT
 	}
 
 	return RunSet{
 		Parameters: param,
 		Runs: []Run{
 			Run{
-				FinalR:         sum(R),
-				MaxI:           maxInfected,
-				Ts:             Ts,
-				Is:             Is,
-				Rs:             Rs,
-				Rts:            Rts,
-				EffectiveBetas: EffectiveBetas,
-				IRisks:         IRisks,
-				SRisks:         SRisks,
-				Duration:       computeOutbreakDuration(Is, param),
-				PeakTime:       computePeakTime(Is, param),
+				FinalR:              sum(R) + sum(st.waned),
+				MaxI:                maxInfected,
+				Truncated:           truncated,
+				Ts:                  Ts,
+				Es:                  nilIfEmpty(Es),
+				Is:                  Is,
+				Rs:                  Rs,
+				Rts:                 Rts,
+				EffectiveBetas:      EffectiveBetas,
+				IRisks:              IRisks,
+				SRisks:              SRisks,
+				RiskyInfections:     RiskyInfections,
+				CommunityInfections: CommunityInfections,
+				Duration:            computeOutbreakDuration(Is, param, interval),
+				PeakTime:            computePeakTime(Is, interval),
+				Waves:               computeWaves(Is, param, interval),
+				InterventionStarts:  schedule.startTimes(),
+				Vaccinated:          vaccinated,
+				Imported:            st.imported[0],
+				CommunityFinalRs:    CommunityFinalRs,
+				CommunityIs:         CommunityIs,
 			},
 		},
 	}
 }
 
+// Everyone in each class at the start, when they are either susceptible or
+// initially infected.
+func initialPopulation(S []float64, initial []float64) []float64 {
+	population := make([]float64, len(S))
+	for c := range population {
+		population[c] = S[c] + initial[c]
+	}
+	return population
+}
+
+// Takes the new infections out of S. Vaccinated and unvaccinated susceptible
+// people in a class are as likely to be infected, so the unvaccinated (if
+// tracked) lose their share.
+func infectSusceptible(S []float64, unvaccinated []float64, newInfections []float64) {
+	for c := range S {
+		if unvaccinated != nil && S[c] > 0 {
+			unvaccinated[c] -= newInfections[c] * unvaccinated[c] / S[c]
+		}
+		S[c] -= newInfections[c]
+	}
+}
+
+// a / b, or 0 if b is 0.
+func ratio(a float64, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+func nilIfEmpty(values []float64) []float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
 // This is kind of complicated.
 // For a differential equation, we can get away with - alpha * S * I; but for
 // a difference equation this overshoots especially when S and I are both kind
 // of big.
 // Instead we have to do something more like S * (1 - alpha)**I
 func newInfectionsDifference(St, I0t, I1t, p, alphaC, alphaR float64) float64 {
-	return St * (1 - math.Pow((1-alphaC), I0t)*(1-p+p*math.Pow((1-alphaR), I1t)))
+	return St * (1 - math.Pow((1-alphaC), I0t)*hotspotEscape(p, alphaR, I1t))
+}
+
+// Probability of not being infected at a hotspot visited with probability p.
+func hotspotEscape(p, alphaR, I1t float64) float64 {
+	return 1 - p + p*math.Pow((1-alphaR), I1t)
+}
+
+// Runs the difference equation, once it has checked that it supports the
+// parameters.
+func RunDifference(param Parameters) (RunSet, error) {
+	check := param
+	check.RunType = Difference
+	if err := check.Validate(); err != nil {
+		return RunSet{}, err
+	}
+	return runDifference(param), nil
 }
 
-func RunDifference(param Parameters) RunSet {
+// Like RunDifference, with parameters already validated.
+func runDifference(param Parameters) RunSet {
 
-	S, I, R := InitializePopulations(param)
+	cls, S, initial, R := initializeClasses(param)
+	numClasses := len(S)
+	hotspots := param.hotspots()
+	q := newChain(len(hotspots), numClasses)
+	momentI := make([]float64, len(hotspots))
+	Es := []float64{}
 	Is := []float64{}
 	Rs := []float64{}
 
-	if param.DiseaseLength != 1.0 {
-		panic("disease length needs to be exactly 1.0 here")
-	}
+	// Without stages, the chains hold a cohort for each day since infection,
+	// so everyone is infectious for exactly DiseaseLength days, as in the
+	// simulation.
+	latent := discreteStages(param.LatentLength, param.LatentStages)
+	infectious := discreteStages(param.DiseaseLength, param.InfectiousStages)
+	E, I := initializeChains(initial, latent, infectious)
 
+	// Each generation lasts one day.
+	schedule := newInterventionSchedule(param.Interventions)
 	maxInfected := -1.0
-	for sumI := sum(I); sumI >= END_THRESHOLD; sumI = sum(I) {
+	waned := 0.0
+	truncated := false
+	campaigns := cls.campaigns(param.Vaccinations, initialPopulation(S, initial))
+	var unvaccinated []float64
+	if campaigns != nil {
+		unvaccinated = append([]float64{}, S...)
+	}
+	vaccinated := 0.0
+	imported := 0.0
+	for sumI, sumE := chainSum(I), chainSum(E); sumI+sumE >= END_THRESHOLD || param.importing(); sumI, sumE = chainSum(I), chainSum(E) {
+		if param.pastMaxTime(float64(len(Is))) {
+			truncated = true
+			break
+		}
+		if campaigns != nil {
+			vaccinated += vaccinateClasses(campaigns, float64(len(Is)), 1, param.N, S, unvaccinated)
+		}
 
+		// Difference runs have a single community.
+		alphaCs, alphaR := schedule.rates(float64(len(Is)), sumI/float64(param.N), param.communities(), hotspots)
+		alphaC := alphaCs[0]
+		if latent.n > 0 {
+			Es = append(Es, sumE)
+		}
 		Is = append(Is, sumI)
 		Rs = append(Rs, sum(R))
 		if sumI > maxInfected {
 			maxInfected = sumI
 		}
 
-		// New infections are the only thing we have to compute: everyone recovers.
-		newInfections := make([]float64, BUCKETS)
+		// New infections are the only thing we have to compute: the stages
+		// say who moves on.
+		newInfections := make([]float64, numClasses)
 
 		// sumI = sum(I)
-		momentI := firstMoment(I, BUCKETS)
-		for b := 0; b < BUCKETS; b++ {
-			risk := riskValue(b, BUCKETS)
-
-			newInfections[b] = newInfectionsDifference(S[b], sumI, momentI, risk, param.BetaC, param.BetaR)
-			// newInfections[b] = (S[b] * math.Pow((1-param.BetaC), sumI) *
-			// 	(1 - risk + risk*math.Pow((1-param.BetaR), momentI)))
+		cls.participation(q, hotspots, sumI, sum(R)+waned, float64(param.N))
+		infectiousI := chainWeightedSum(I, cls.infectiousness)
+		for k := range hotspots {
+			momentI[k] = chainWeightedSum2(I, q[k], cls.infectiousness)
+		}
+		for c := 0; c < numClasses; c++ {
+			// The chances of escaping infection in the community and at
+			// each hotspot multiply.
+			escape := math.Pow((1 - alphaC), infectiousI)
+			for k := range hotspots {
+				escape *= hotspotEscape(q[k][c], alphaR[k], momentI[k])
+			}
+			newInfections[c] = S[c] * (1 - escape)
+		}
+		if param.importing() {
+			before := sum(newInfections)
+			cls.seed(S, param.Seeding.ImportationRate, newInfections)
+			imported += sum(newInfections) - before
 		}
 
-		for b := 0; b < BUCKETS; b++ {
-			S[b] -= newInfections[b]
-			R[b] += I[b]
-			I[b] = newInfections[b]
+		infectSusceptible(S, unvaccinated, newInfections)
+		becomingInfectious := make([]float64, numClasses)
+		advance(E, newInfections, becomingInfectious, latent, 1)
+		advance(I, becomingInfectious, R, infectious, 1)
+		if param.WaningRate > 0 {
+			for c := 0; c < numClasses; c++ {
+				waning := R[c] * param.WaningRate
+				R[c] -= waning
+				S[c] += waning
+				waned += waning
+				if unvaccinated != nil {
+					unvaccinated[c] += waning
+				}
+			}
 		}
 	}
 
@@ -207,10 +694,18 @@ func RunDifference(param Parameters) RunSet {
 		Parameters: param,
 		Runs: []Run{
 			Run{
-				FinalR: sum(R),
-				MaxI:   maxInfected,
-				Is:     Is,
-				Rs:     Rs,
+				FinalR:             sum(R) + waned,
+				MaxI:               maxInfected,
+				Truncated:          truncated,
+				Es:                 nilIfEmpty(Es),
+				Is:                 Is,
+				Rs:                 Rs,
+				Duration:           computeOutbreakDuration(Is, param, 1),
+				PeakTime:           computePeakTime(Is, 1),
+				Waves:              computeWaves(Is, param, 1),
+				InterventionStarts: schedule.startTimes(),
+				Vaccinated:         vaccinated,
+				Imported:           imported,
 			},
 		},
 	}
@@ -19,6 +19,17 @@ var defaultParameters Parameters = Parameters{
 	Trials:        1,
 }
 
+// An epidemic that takes off, shared by the tests of every model:
+// defaultParameters with R0 2.5 split evenly between the community and the
+// hotspot, running each trial to the end and recording its compartments.
+func sirParameters() Parameters {
+	param := defaultParameters
+	param.R0, param.HotspotFraction, param.RiskMean, param.RiskVariance = 2.5, 0.5, 0.25, MediumVar
+	param.Seed, param.Extinction, param.Record = 7, FullRun, RecordCompartments
+	param.ComputeBetas()
+	return param
+}
+
 func TestRiskValue(t *testing.T) {
 	for _, test := range []struct {
 		b       int
@@ -136,3 +147,36 @@ func TestNewInfectionsDifference(t *testing.T) {
 		}
 	}
 }
+
+// Everyone is infectious for exactly DiseaseLength days, as in the
+// simulation, and the final size is that of the final size equation,
+// z = 1 - (1 - i) exp(-R0 z), whatever the disease length.
+func TestDifferenceDiseaseLength(t *testing.T) {
+	for _, D := range []int{1, 2, 4, 8} {
+		param := sirParameters()
+		param.HotspotFraction, param.DiseaseLength, param.R0 = 0, D, 0
+		param.ComputeBetas()
+		if run := runDifference(param).Runs[0]; len(run.Is) != D || run.FinalR != INITIAL_INFECTEDS {
+			t.Errorf("DiseaseLength %d: infected %v without transmission", D, run.Is)
+		}
+
+		param.R0, param.N = 2, 100000
+		param.ComputeBetas()
+		z := runDifference(param).Runs[0].FinalR / float64(param.N)
+		i := INITIAL_INFECTEDS / float64(param.N)
+		if residual := z - 1 + (1-i)*math.Exp(-2*z); math.Abs(residual) > 1e-4 {
+			t.Errorf("DiseaseLength %d: final size %v is off the final size equation by %v", D, z, residual)
+		}
+	}
+
+	// Without a day of disease there is nobody to infect anyone.
+	param := sirParameters()
+	param.DiseaseLength = 0
+	if _, err := RunDifference(param); err == nil {
+		t.Errorf("ran the difference equation without a disease length")
+	}
+	param.DiseaseLength = 1
+	if _, err := RunDifference(param); err != nil {
+		t.Error(err)
+	}
+}
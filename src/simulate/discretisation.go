@@ -0,0 +1,211 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// How the differential and difference equations split the risk tolerance
+// distribution into buckets.
+type Discretisation string
+
+const (
+	// Buckets of equal width, each at its midpoint.
+	MidpointBuckets Discretisation = ""
+	// The nodes and weights of Gauss-Jacobi quadrature for the risk
+	// distribution, which follow its mass where it piles up near 0 or 1.
+	GaussJacobiNodes Discretisation = "gauss-jacobi"
+	// Buckets holding equal shares of the population, each at the mean risk
+	// of the people in it.
+	QuantileBuckets Discretisation = "quantile"
+)
+
+// The number of buckets of the reference solution in DiscretisationConvergence.
+const REFERENCE_BUCKETS = 200
+
+// The number of risk buckets: Parameters.Buckets, or BUCKETS.
+func (param Parameters) buckets() int {
+	if param.Buckets > 0 {
+		return param.Buckets
+	}
+	return BUCKETS
+}
+
+func (param Parameters) validateDiscretisation() error {
+	switch param.Discretisation {
+	case MidpointBuckets, GaussJacobiNodes, QuantileBuckets:
+	default:
+		return fmt.Errorf("unknown discretisation %q", param.Discretisation)
+	}
+	if param.Buckets < 0 {
+		return fmt.Errorf("buckets must not be negative, got %d", param.Buckets)
+	}
+	if (param.Discretisation != MidpointBuckets || param.Buckets > 0) && param.RunType == Simulation {
+		return fmt.Errorf("%s runs don't split risk into buckets", param.RunType)
+	}
+	// Gauss-Jacobi rules come from an eigendecomposition, which may fail.
+	if param.Discretisation == GaussJacobiNodes {
+		for _, community := range param.communities() {
+			if _, _, err := riskBuckets(community.RiskDist, param.buckets(), param.Discretisation); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The risk of each bucket and the share of the population in it, for the
+// given risk distribution (uniform if nil).
+func riskBuckets(riskDist *RiskDistribution, buckets int, kind Discretisation) ([]float64, []float64, error) {
+	A, B := 1.0, 1.0
+	if riskDist != nil {
+		A, B = riskDist.A, riskDist.B
+	}
+	switch kind {
+	case GaussJacobiNodes:
+		return gaussJacobi(A, B, buckets)
+	case QuantileBuckets:
+		risk, mass := quantileBuckets(A, B, buckets)
+		return risk, mass, nil
+	}
+	beta := distuv.Beta{Alpha: A, Beta: B, Src: nil}
+	risk := make([]float64, buckets)
+	mass := make([]float64, buckets)
+	for b := 0; b < buckets; b++ {
+		risk[b] = riskValue(b, buckets)
+		// each bucket should have this much mass in it cdf(x+1) - cdf(x)
+		mass[b] = beta.CDF(float64(b+1)/float64(buckets)) - beta.CDF(float64(b)/float64(buckets))
+	}
+	return risk, mass, nil
+}
+
+// The risk buckets of a validated run, panicking if there are none since
+// Validate has checked them.
+func (param Parameters) riskBuckets(riskDist *RiskDistribution) ([]float64, []float64) {
+	risk, mass, err := riskBuckets(riskDist, param.buckets(), param.Discretisation)
+	if err != nil {
+		panic(err)
+	}
+	return risk, mass
+}
+
+// The n point Gauss quadrature rule for the Beta(A, B) distribution, from the
+// eigenvalues and eigenvectors of the Jacobi matrix of its orthogonal
+// polynomials (Golub and Welsch). These are the Jacobi polynomials with
+// alpha = B - 1 and beta = A - 1, moved from [-1, 1] to [0, 1].
+func gaussJacobi(A float64, B float64, n int) ([]float64, []float64, error) {
+	alpha, beta := B-1, A-1
+	jacobi := mat.NewSymDense(n, nil)
+	for k := 0; k < n; k++ {
+		s := 2*float64(k) + alpha + beta
+		diagonal := (beta - alpha) / (alpha + beta + 2)
+		if k > 0 {
+			diagonal = (beta*beta - alpha*alpha) / (s * (s + 2))
+		}
+		jacobi.SetSym(k, k, (1+diagonal)/2)
+		if k+1 < n {
+			m := float64(k + 1)
+			s := 2*m + alpha + beta
+			// With m = 1 a factor of alpha + beta + 1 cancels out, which
+			// could be 0.
+			offDiagonal := 4 * (m + alpha) * (m + beta) / (s * s * (s + 1))
+			if k > 0 {
+				offDiagonal *= m * (m + alpha + beta) / (s - 1)
+			}
+			jacobi.SetSym(k, k+1, math.Sqrt(offDiagonal)/2)
+		}
+	}
+	var eigen mat.EigenSym
+	if !eigen.Factorize(jacobi, true) {
+		return nil, nil, fmt.Errorf("no Gauss-Jacobi rule for Beta(%v, %v) with %d nodes", A, B, n)
+	}
+	var vectors mat.Dense
+	eigen.VectorsTo(&vectors)
+	nodes := eigen.Values(nil)
+	weights := make([]float64, n)
+	for k := range weights {
+		weights[k] = vectors.At(0, k) * vectors.At(0, k)
+	}
+	return nodes, weights, nil
+}
+
+// n buckets of the Beta(A, B) distribution with equal mass, each at its
+// conditional mean: the mean of X given that X is in [l, u] is
+// A / (A + B) * (F'(u) - F'(l)) / (F(u) - F(l)), where F' is the CDF of
+// Beta(A + 1, B).
+func quantileBuckets(A float64, B float64, n int) ([]float64, []float64) {
+	beta := distuv.Beta{Alpha: A, Beta: B}
+	shifted := distuv.Beta{Alpha: A + 1, Beta: B}
+	risk := make([]float64, n)
+	mass := make([]float64, n)
+	lower := 0.0
+	for b := range risk {
+		upper := 1.0
+		if b+1 < n {
+			upper = beta.Quantile(float64(b+1) / float64(n))
+		}
+		mass[b] = 1 / float64(n)
+		risk[b] = A / (A + B) * (shifted.CDF(upper) - shifted.CDF(lower)) * float64(n)
+		lower = upper
+	}
+	return risk, mass
+}
+
+// A point of DiscretisationConvergence.
+type ConvergencePoint struct {
+	Discretisation Discretisation
+	Buckets        int
+	FinalR         float64
+	// The difference from the reference FinalR.
+	Error float64
+}
+
+// Runs the differential or difference equation with each discretisation and
+// number of buckets, and compares FinalR to that with REFERENCE_BUCKETS
+// Gauss-Jacobi nodes. Fails for other run types, or if any of them isn't
+// valid.
+func DiscretisationConvergence(param Parameters, buckets []int) ([]ConvergencePoint, error) {
+	var run func(Parameters) RunSet
+	switch param.RunType {
+	case DifEq:
+		run = RunDifEq
+	case Difference:
+		run = runDifference
+	default:
+		return nil, fmt.Errorf("convergence compares %s or %s runs, not %s", DifEq, Difference, param.RunType)
+	}
+	measure := func(p Parameters) (float64, error) {
+		if err := p.Validate(); err != nil {
+			return 0, err
+		}
+		return run(p).Runs[0].FinalR, nil
+	}
+	reference := param
+	reference.Discretisation, reference.Buckets = GaussJacobiNodes, REFERENCE_BUCKETS
+	want, err := measure(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []ConvergencePoint{}
+	for _, kind := range []Discretisation{MidpointBuckets, GaussJacobiNodes, QuantileBuckets} {
+		for _, n := range buckets {
+			p := param
+			p.Discretisation, p.Buckets = kind, n
+			finalR, err := measure(p)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, ConvergencePoint{
+				Discretisation: kind,
+				Buckets:        n,
+				FinalR:         finalR,
+				Error:          finalR - want,
+			})
+		}
+	}
+	return points, nil
+}
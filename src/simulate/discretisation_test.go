@@ -0,0 +1,91 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+// Every discretisation keeps the whole population, and the Gauss-Jacobi
+// and quantile buckets the mean risk; Gauss-Jacobi nodes also have the
+// right variance.
+func TestRiskBuckets(t *testing.T) {
+	for _, riskDist := range []*RiskDistribution{nil, RiskDist(0.25, HighVar), RiskDist(0.5, LowVar)} {
+		A, B := 1.0, 1.0
+		if riskDist != nil {
+			A, B = riskDist.A, riskDist.B
+		}
+		mean := A / (A + B)
+		variance := A * B / ((A + B) * (A + B) * (A + B + 1))
+		for _, kind := range []Discretisation{MidpointBuckets, GaussJacobiNodes, QuantileBuckets} {
+			risk, mass, err := riskBuckets(riskDist, 20, kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(risk) != 20 || math.Abs(sum(mass)-1) > 1e-9 {
+				t.Fatalf("%q buckets of %+v: %v buckets with mass %v", kind, riskDist, len(risk), sum(mass))
+			}
+			for _, r := range risk {
+				if r < 0 || r > 1 {
+					t.Fatalf("%q buckets of %+v have risk %v", kind, riskDist, r)
+				}
+			}
+			if kind == MidpointBuckets {
+				continue
+			}
+			if got := weightedSum(mass, risk); math.Abs(got-mean) > 1e-9 {
+				t.Errorf("%q buckets of %+v have mean %v; want %v", kind, riskDist, got, mean)
+			}
+			if kind == GaussJacobiNodes {
+				if got := weightedSum2(mass, risk, risk) - mean*mean; math.Abs(got-variance) > 1e-9 {
+					t.Errorf("Gauss-Jacobi nodes of %+v have variance %v; want %v", riskDist, got, variance)
+				}
+			}
+		}
+	}
+
+	for _, param := range []Parameters{
+		{RunType: DifEq, Discretisation: "chebyshev"},
+		{RunType: DifEq, Buckets: -1},
+		{RunType: Simulation, Discretisation: QuantileBuckets},
+	} {
+		if err := param.validateDiscretisation(); err == nil {
+			t.Errorf("%+v passed validation", param)
+		}
+	}
+}
+
+// With the high variance distribution, whose mass piles up near 0 and 1,
+// FinalR converges as the buckets get finer, much faster with Gauss-Jacobi
+// nodes than with buckets of equal width.
+func TestDiscretisationConvergence(t *testing.T) {
+	param := sirParameters()
+	param.RunType = DifEq
+	param.RiskVariance = HighVar
+	param.HotspotFraction = 0.8
+	param.ComputeBetas()
+	points, err := DiscretisationConvergence(param, []int{10, 50, 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errors := map[Discretisation][]float64{}
+	for _, point := range points {
+		errors[point.Discretisation] = append(errors[point.Discretisation], math.Abs(point.Error))
+	}
+	for _, kind := range []Discretisation{MidpointBuckets, QuantileBuckets} {
+		e := errors[kind]
+		if !(e[0] > e[1] && e[1] > e[2]) {
+			t.Errorf("%q buckets: FinalR errors %v don't shrink with more buckets", kind, e)
+		}
+	}
+	if gauss, midpoint := errors[GaussJacobiNodes][0], errors[MidpointBuckets][2]; gauss > 0.01 || gauss > midpoint/100 {
+		t.Errorf("FinalR error %v with 10 Gauss-Jacobi nodes; %v with 200 equal buckets", gauss, midpoint)
+	}
+
+	// Only the equations that split risk into buckets converge.
+	for _, runType := range []RunType{Simulation, Analytic} {
+		param.RunType = runType
+		if _, err := DiscretisationConvergence(param, []int{10}); err == nil {
+			t.Errorf("compared the discretisations of %s runs", runType)
+		}
+	}
+}
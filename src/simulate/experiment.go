@@ -0,0 +1,283 @@
+package simulate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// An Experiment describes a sweep over Parameters. Every combination of the
+// axes is run; points that only differ in R0 are grouped into one R0Series.
+type Experiment struct {
+	Name string `json:",omitempty"`
+	// Point i of the expanded experiment is seeded with SubSeed(Seed, i).
+	Seed uint64
+	// Settings shared by every point, e.g. Record. The axes below take
+	// precedence over the corresponding fields.
+	Parameters *Parameters `json:",omitempty"`
+
+	RunType         RunTypes
+	N               Axis
+	Trials          Axis
+	DiseaseLength   Axis
+	HotspotFraction Axis
+	RiskMean        Axis
+	RiskVariance    RiskVariances
+	R0              Axis
+}
+
+// The values taken along one numeric axis of an Experiment: an explicit list,
+// a linear range or a logarithmic range. In a spec file an axis can also be
+// written as a bare number or a bare list.
+type Axis struct {
+	Values   []float64 `json:",omitempty"`
+	Range    *Range    `json:",omitempty"`
+	LogRange *LogRange `json:",omitempty"`
+}
+
+// Start, Start+Step, ... up to and including End.
+type Range struct {
+	Start, End, Step float64
+}
+
+// Num values evenly spaced in log space from Start to End inclusive.
+type LogRange struct {
+	Start, End float64
+	Num        int
+}
+
+type RunTypes []RunType
+type RiskVariances []RiskVariance
+
+// Everything produced by running an Experiment, along with the Experiment
+// itself so that the output file is enough to reproduce it.
+type ExperimentResults struct {
+	Experiment Experiment
+	Series     []R0Series
+}
+
+func (a *Axis) UnmarshalJSON(data []byte) error {
+	var value float64
+	if err := json.Unmarshal(data, &value); err == nil {
+		a.Values = []float64{value}
+		return nil
+	}
+	var values []float64
+	if err := json.Unmarshal(data, &values); err == nil {
+		a.Values = values
+		return nil
+	}
+	// Avoid recursing into this method.
+	type axis Axis
+	return json.Unmarshal(data, (*axis)(a))
+}
+
+func (r *RunTypes) UnmarshalJSON(data []byte) error {
+	var value RunType
+	if err := json.Unmarshal(data, &value); err == nil {
+		*r = RunTypes{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]RunType)(r))
+}
+
+func (r *RiskVariances) UnmarshalJSON(data []byte) error {
+	var value RiskVariance
+	if err := json.Unmarshal(data, &value); err == nil {
+		*r = RiskVariances{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]RiskVariance)(r))
+}
+
+func (a Axis) IsEmpty() bool {
+	return len(a.Values) == 0 && a.Range == nil && a.LogRange == nil
+}
+
+// Lists the values along the axis, in order: explicit values first, then the
+// range, then the log range.
+func (a Axis) Expand() ([]float64, error) {
+	values := append([]float64{}, a.Values...)
+	if r := a.Range; r != nil {
+		if r.Step <= 0 {
+			return nil, fmt.Errorf("range step must be positive, got %v", r.Step)
+		}
+		// Compute each value from its index so that rounding errors don't
+		// accumulate, and allow a little slack so End itself is included.
+		for i := 0; ; i++ {
+			value := r.Start + float64(i)*r.Step
+			if value > r.End+r.Step*1e-9 {
+				break
+			}
+			values = append(values, value)
+		}
+	}
+	if r := a.LogRange; r != nil {
+		if r.Start <= 0 || r.End <= 0 {
+			return nil, fmt.Errorf("log range bounds must be positive, got %v and %v", r.Start, r.End)
+		}
+		if r.Num < 1 {
+			return nil, fmt.Errorf("log range needs at least one value, got %v", r.Num)
+		}
+		if r.Num == 1 {
+			values = append(values, r.Start)
+		} else {
+			logStart, logEnd := math.Log(r.Start), math.Log(r.End)
+			for i := 0; i < r.Num; i++ {
+				values = append(values, math.Exp(logStart+(logEnd-logStart)*float64(i)/float64(r.Num-1)))
+			}
+		}
+	}
+	return values, nil
+}
+
+// Expands an integer valued axis, e.g. N or Trials.
+func (a Axis) expandInts(name string) ([]int, error) {
+	values, err := a.Expand()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	ints := make([]int, len(values))
+	for i, value := range values {
+		ints[i] = int(math.Round(value))
+		if math.Abs(value-float64(ints[i])) > 1e-9 {
+			return nil, fmt.Errorf("%s: %v is not a whole number", name, value)
+		}
+	}
+	return ints, nil
+}
+
+func (e Experiment) Validate() error {
+	missing := []string{}
+	for _, axis := range []struct {
+		name  string
+		empty bool
+	}{
+		{"RunType", len(e.RunType) == 0},
+		{"N", e.N.IsEmpty()},
+		{"Trials", e.Trials.IsEmpty()},
+		{"DiseaseLength", e.DiseaseLength.IsEmpty()},
+		{"HotspotFraction", e.HotspotFraction.IsEmpty()},
+		{"RiskMean", e.RiskMean.IsEmpty()},
+		{"RiskVariance", len(e.RiskVariance) == 0},
+		{"R0", e.R0.IsEmpty()},
+	} {
+		if axis.empty {
+			missing = append(missing, axis.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("experiment has no values for %s", strings.Join(missing, ", "))
+	}
+	for _, runType := range e.RunType {
+		switch runType {
+		case Simulation, DifEq, Difference, Analytic:
+		default:
+			return fmt.Errorf("unknown run type %q", runType)
+		}
+	}
+	for _, riskVariance := range e.RiskVariance {
+		switch riskVariance {
+		case LowVar, MediumVar, HighVar:
+		default:
+			return fmt.Errorf("unknown risk variance %q", riskVariance)
+		}
+	}
+	return nil
+}
+
+// Expands the experiment into R0Series whose RunSets carry the Parameters of
+// every point but no Runs yet. Axes are nested in the order RunType, N,
+// Trials, DiseaseLength, HotspotFraction, RiskMean, RiskVariance, with R0
+// varying within each series.
+func (e Experiment) Expand() ([]R0Series, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	ns, err := e.N.expandInts("N")
+	if err != nil {
+		return nil, err
+	}
+	trials, err := e.Trials.expandInts("Trials")
+	if err != nil {
+		return nil, err
+	}
+	diseaseLengths, err := e.DiseaseLength.expandInts("DiseaseLength")
+	if err != nil {
+		return nil, err
+	}
+	floatAxes := make([][]float64, 3)
+	for i, axis := range []struct {
+		name string
+		axis Axis
+	}{
+		{"HotspotFraction", e.HotspotFraction},
+		{"RiskMean", e.RiskMean},
+		{"R0", e.R0},
+	} {
+		if floatAxes[i], err = axis.axis.Expand(); err != nil {
+			return nil, fmt.Errorf("%s: %v", axis.name, err)
+		}
+	}
+	hotspotFractions, riskMeans, R0s := floatAxes[0], floatAxes[1], floatAxes[2]
+
+	for _, n := range ns {
+		if n <= 0 {
+			return nil, errors.New("N must be positive")
+		}
+	}
+	for _, diseaseLength := range diseaseLengths {
+		if diseaseLength <= 0 {
+			return nil, errors.New("DiseaseLength must be positive")
+		}
+	}
+
+	allSeries := []R0Series{}
+	point := 0
+	for _, runType := range e.RunType {
+		for _, n := range ns {
+			for _, trial := range trials {
+				for _, diseaseLength := range diseaseLengths {
+					for _, hotspotFraction := range hotspotFractions {
+						for _, riskMean := range riskMeans {
+							for _, riskVariance := range e.RiskVariance {
+								series := R0Series{
+									RunType:         runType,
+									RiskMean:        riskMean,
+									RiskVariance:    riskVariance,
+									HotspotFraction: hotspotFraction,
+									RunSets:         make([]RunSet, 0, len(R0s)),
+								}
+								for _, R0 := range R0s {
+									param := Parameters{}
+									if e.Parameters != nil {
+										param = *e.Parameters
+									}
+									param.N = n
+									param.DiseaseLength = diseaseLength
+									param.RunType = runType
+									param.R0 = R0
+									param.HotspotFraction = hotspotFraction
+									param.RiskMean = riskMean
+									param.RiskVariance = riskVariance
+									param.Trials = trial
+									param.Seed = SubSeed(e.Seed, point)
+									param.ComputeBetas()
+									if err := param.Validate(); err != nil {
+										return nil, err
+									}
+									point++
+									series.RunSets = append(series.RunSets, RunSet{Parameters: param})
+								}
+								allSeries = append(allSeries, series)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return allSeries, nil
+}
@@ -0,0 +1,141 @@
+package simulate
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAxisExpand(t *testing.T) {
+	for _, test := range []struct {
+		axis Axis
+		want []float64
+	}{
+		{Axis{Values: []float64{0.5, 0.25}}, []float64{0.5, 0.25}},
+		{Axis{Range: &Range{0, 1, 0.25}}, []float64{0, 0.25, 0.5, 0.75, 1}},
+		// End is included even though 100 * 0.01 accumulates rounding error.
+		{Axis{Range: &Range{0, 1, 0.01}}, nil},
+		{Axis{LogRange: &LogRange{0.01, 1, 3}}, []float64{0.01, 0.1, 1}},
+		{Axis{Values: []float64{2}, Range: &Range{0, 1, 1}}, []float64{2, 0, 1}},
+	} {
+		got, err := test.axis.Expand()
+		if err != nil {
+			t.Fatalf("%+v.Expand() returned error %v", test.axis, err)
+		}
+		if test.want == nil {
+			if len(got) != 101 || math.Abs(got[100]-1.0) > tolerance {
+				t.Fatalf("%+v.Expand() has %v values ending at %v; want 101 ending at 1",
+					test.axis, len(got), got[len(got)-1])
+			}
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Fatalf("%+v.Expand() = %v; want %v", test.axis, got, test.want)
+		}
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > tolerance {
+				t.Fatalf("%+v.Expand() = %v; want %v", test.axis, got, test.want)
+			}
+		}
+	}
+}
+
+func TestAxisUnmarshal(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want Axis
+	}{
+		{`2`, Axis{Values: []float64{2}}},
+		{`[1, 2]`, Axis{Values: []float64{1, 2}}},
+		{`{"Range": {"Start": 0, "End": 5, "Step": 0.1}}`, Axis{Range: &Range{0, 5, 0.1}}},
+		{`{"LogRange": {"Start": 0.01, "End": 1, "Num": 5}}`, Axis{LogRange: &LogRange{0.01, 1, 5}}},
+	} {
+		var got Axis
+		if err := json.Unmarshal([]byte(test.spec), &got); err != nil {
+			t.Fatalf("unmarshalling %s: %v", test.spec, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("unmarshalling %s = %+v; want %+v", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestExperimentExpand(t *testing.T) {
+	var experiment Experiment
+	spec := `{
+		"RunType": "difeq",
+		"N": 1000,
+		"Trials": 1,
+		"DiseaseLength": [1, 2],
+		"HotspotFraction": [0, 0.5],
+		"RiskMean": 0.25,
+		"RiskVariance": ["low", "high"],
+		"R0": {"Range": {"Start": 0, "End": 2, "Step": 1}}
+	}`
+	if err := json.Unmarshal([]byte(spec), &experiment); err != nil {
+		t.Fatal(err)
+	}
+	allSeries, err := experiment.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allSeries) != 2*2*2 {
+		t.Fatalf("got %v series; want 8", len(allSeries))
+	}
+	for _, series := range allSeries {
+		if len(series.RunSets) != 3 {
+			t.Fatalf("got %v RunSets in series; want 3", len(series.RunSets))
+		}
+		for r, runSet := range series.RunSets {
+			param := runSet.Parameters
+			if param.R0 != float64(r) || param.RunType != DifEq || param.HotspotFraction != series.HotspotFraction {
+				t.Fatalf("unexpected parameters %+v in series %+v", param, series)
+			}
+		}
+	}
+	// The innermost series axis is RiskVariance, then HotspotFraction.
+	if allSeries[1].RiskVariance != HighVar || allSeries[2].HotspotFraction != 0.5 {
+		t.Fatalf("series are not nested in the expected order")
+	}
+
+	// The betas match the ones computed by hand for a single point.
+	param := allSeries[3].RunSets[2].Parameters
+	want := 1.0 * (2.0 * 0.5 / 0.25 / 0.25) / 1000
+	if math.Abs(param.BetaR-want) > tolerance || param.RiskDist == nil {
+		t.Fatalf("BetaR = %v; want %v", param.BetaR, want)
+	}
+
+	experiment.RiskMean = Axis{}
+	if _, err := experiment.Expand(); err == nil {
+		t.Fatalf("expected an error for an experiment without RiskMean")
+	}
+}
+
+// The shared Parameters are only validated at each point, once N and the run
+// type are known: an edge list can only be checked against N.
+func TestExperimentSharedParameters(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "edges.txt")
+	os.WriteFile(fileName, []byte("0 1\n1 2\n"), 0644)
+	one := Axis{Values: []float64{1}}
+	experiment := Experiment{
+		Parameters:      &Parameters{Network: &Network{Kind: EdgeList, File: fileName}},
+		RunType:         RunTypes{Simulation},
+		N:               Axis{Values: []float64{10}},
+		Trials:          one,
+		DiseaseLength:   one,
+		HotspotFraction: Axis{Values: []float64{0.5}},
+		RiskMean:        Axis{Values: []float64{0.25}},
+		RiskVariance:    RiskVariances{MediumVar},
+		R0:              Axis{Values: []float64{2}},
+	}
+	if _, err := experiment.Expand(); err != nil {
+		t.Fatal(err)
+	}
+	experiment.N = Axis{Values: []float64{2}}
+	if _, err := experiment.Expand(); err == nil {
+		t.Errorf("expanded an edge list with agent 2 in a population of 2")
+	}
+}
@@ -0,0 +1,178 @@
+package simulate
+
+import (
+	"math"
+)
+
+// The extinction probabilities of the branching process are iterated until a
+// generation changes them by less than this, or for at most
+// EXTINCTION_ITERATIONS generations, which only matters right at the
+// threshold.
+const EXTINCTION_TOLERANCE = 1e-10
+const EXTINCTION_ITERATIONS = 10000
+
+// The theoretical chance that a simulation's outbreak dies out, next to how
+// often it did.
+type Extinction struct {
+	// From ExtinctionProbability.
+	Probability float64
+	// The fraction of the Runs in which at least the extinction cutoff were
+	// infected.
+	OutbreakFraction float64
+}
+
+// The offspring of someone infectious for a day, who goes to some of the
+// hotspots that day.
+type offspring struct {
+	// The probability that they go to just those hotspots.
+	probability float64
+	// The probability that they infect each susceptible of each class.
+	infected []float64
+}
+
+// The start of a simulation's outbreak as a multi-type branching process over
+// the classes of the differential equation, while nearly everyone is still
+// susceptible: every day someone is infectious, they infect each susceptible
+// independently, as in the simulation.
+type branchingProcess struct {
+	// The offspring of someone of each class on a day, for each combination
+	// of hotspots they may go to.
+	offspring [][]offspring
+	// The susceptible of each class, and the seeds.
+	S, seeds   []float64
+	infectious stages
+}
+
+func newBranchingProcess(param Parameters) branchingProcess {
+	m := newMeanField(param)
+	communities := len(param.communities())
+	hotspots := len(m.beta) - communities
+	b := branchingProcess{
+		offspring:  make([][]offspring, len(m.P)),
+		S:          m.S0,
+		seeds:      make([]float64, len(m.P)),
+		infectious: discreteStages(param.DiseaseLength, param.InfectiousStages),
+	}
+	for c := range m.P {
+		b.seeds[c] = m.P[c] - m.S0[c]
+	}
+	for i := range m.P {
+		// Someone who counts as alpha infectious contacts infects each
+		// susceptible they meet with probability 1 - (1 - beta)^alpha.
+		alpha, home := m.cls.infectiousness[i], m.cls.community[i]
+		community := 1 - math.Pow(1-m.beta[home], alpha)
+		for visits := 0; visits < 1<<hotspots; visits++ {
+			o := offspring{probability: 1, infected: make([]float64, len(m.P))}
+			escape := make([]float64, len(m.P))
+			for c := range escape {
+				escape[c] = 1 - community*m.exposure[home][c]
+			}
+			for k := 0; k < hotspots; k++ {
+				j := communities + k
+				if visits&(1<<k) == 0 {
+					o.probability *= 1 - m.exposure[j][i]
+					continue
+				}
+				o.probability *= m.exposure[j][i]
+				hotspot := 1 - math.Pow(1-m.beta[j], alpha)
+				for c := range escape {
+					escape[c] *= 1 - hotspot*m.exposure[j][c]
+				}
+			}
+			if o.probability == 0 {
+				continue
+			}
+			for c := range escape {
+				o.infected[c] = 1 - escape[c]
+			}
+			b.offspring[i] = append(b.offspring[i], o)
+		}
+	}
+	return b
+}
+
+// The probability that the outbreak started by someone of class i dies out,
+// if one started by someone of class c dies out with probability q[c]: the
+// probability generating function of their offspring, at q. Each day of the
+// infectious period is independent, and the number of days in each of its
+// stages is geometric.
+func (b branchingProcess) generating(i int, q []float64) float64 {
+	g := 0.0
+	for _, o := range b.offspring[i] {
+		logEscape := 0.0
+		for c, infected := range o.infected {
+			if infected > 0 {
+				logEscape += b.S[c] * math.Log1p(-infected*(1-q[c]))
+			}
+		}
+		g += o.probability * math.Exp(logEscape)
+	}
+	return math.Pow(b.infectious.leave*g/(1-(1-b.infectious.leave)*g), float64(b.infectious.n))
+}
+
+// The extinction probability of an outbreak started by someone of each class:
+// the smallest fixed point of the generating functions, which iterating them
+// from 0 converges to.
+func (b branchingProcess) extinction() []float64 {
+	q := make([]float64, len(b.S))
+	next := make([]float64, len(b.S))
+	for iteration := 0; iteration < EXTINCTION_ITERATIONS; iteration++ {
+		change := 0.0
+		for i := range next {
+			next[i] = b.generating(i, q)
+			change = math.Max(change, math.Abs(next[i]-q[i]))
+		}
+		q, next = next, q
+		if change < EXTINCTION_TOLERANCE {
+			break
+		}
+	}
+	return q
+}
+
+// The probability that a simulation's outbreak dies out before many are
+// infected, for any risk distribution, DiseaseLength and stages, hotspots,
+// communities and infectiousness. It follows the spread of the seeds, or of
+// INITIAL_INFECTED people picked at random, while nearly everyone is still
+// susceptible, and at the rates before any intervention. The risk tolerances
+// are split into buckets like those of the differential equation.
+func ExtinctionProbability(param Parameters) float64 {
+	b := newBranchingProcess(param)
+	q := b.extinction()
+	// Seeds picked at random are each in a class with probability in
+	// proportion to its seeds, and targeted ones are in those classes.
+	if param.Seeding == nil || param.Seeding.Target == RandomSeeds {
+		count := float64(INITIAL_INFECTED)
+		if param.Seeding != nil {
+			count = float64(param.Seeding.Count)
+		}
+		return math.Pow(weightedSum(b.seeds, q)/sum(b.seeds), count)
+	}
+	logExtinction := 0.0
+	for c, seeds := range b.seeds {
+		if seeds > 0 {
+			logExtinction += seeds * math.Log(q[c])
+		}
+	}
+	return math.Exp(logExtinction)
+}
+
+// The theoretical and simulated extinction of a simulation, or nil if it
+// wasn't asked for or the branching process doesn't describe it: on a
+// contact network, or if infections keep being imported.
+func simulatedExtinction(runSet RunSet) *Extinction {
+	param := runSet.Parameters
+	if !param.PredictExtinction || param.Network != nil || param.importing() || len(runSet.Runs) == 0 {
+		return nil
+	}
+	outbreaks := 0
+	for _, run := range runSet.Runs {
+		if run.FinalR >= float64(param.extinctionCutoff()) {
+			outbreaks++
+		}
+	}
+	return &Extinction{
+		Probability:      ExtinctionProbability(param),
+		OutbreakFraction: float64(outbreaks) / float64(len(runSet.Runs)),
+	}
+}
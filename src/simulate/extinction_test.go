@@ -0,0 +1,59 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+// Without the hotspot everyone infects Binomial(N, BetaC) people a day, so
+// the extinction probability q solves q = (1 - BetaC (1 - q))^(N D), as in
+// binomial_G of figures/extinction.py.
+func TestExtinctionHomogeneous(t *testing.T) {
+	for _, D := range []int{1, 3} {
+		param := sirParameters()
+		param.HotspotFraction, param.DiseaseLength = 0, D
+		param.ComputeBetas()
+		lower, upper := 0.0, 1.0
+		for i := 0; i < 100; i++ {
+			q := (lower + upper) / 2
+			if math.Pow(1-param.BetaC*(1-q), (float64(param.N)-INITIAL_INFECTEDS)*float64(D)) < q {
+				upper = q
+			} else {
+				lower = q
+			}
+		}
+		if got := ExtinctionProbability(param); math.Abs(got-lower) > 1e-6 {
+			t.Errorf("DiseaseLength %d: extinction probability %v; want %v", D, got, lower)
+		}
+	}
+}
+
+// The simulated outbreaks break out about as often as the branching process
+// predicts, whatever the risk distribution, period and seeding.
+func TestExtinctionMatchesSimulation(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(*Parameters)
+	}{
+		{"high variance", func(*Parameters) {}},
+		{"stages", func(p *Parameters) { p.DiseaseLength, p.InfectiousStages = 3, 2 }},
+		{"riskiest seeds", func(p *Parameters) { p.Seeding = &Seeding{Count: 2, Target: HighestRiskSeeds} }},
+		{"superspreading", func(p *Parameters) { p.AlphaDist = &AlphaDistribution{Shape: GammaAlpha, Mu: 1, Std: 3} }},
+	} {
+		param := sirParameters()
+		param.R0, param.RiskVariance, param.HotspotFraction = 1.5, HighVar, 0.8
+		param.Trials, param.Extinction, param.Record = 2000, StopAtCutoff, RecordNone
+		param.PredictExtinction = true
+		test.change(&param)
+		param.ComputeBetas()
+		extinction := RunSimulation(param).Extinction
+		if extinction == nil {
+			t.Fatalf("%s: no extinction probability", test.name)
+		}
+		// Within four standard errors.
+		outbreak := 1 - extinction.Probability
+		if tolerance := 4 * math.Sqrt(outbreak*(1-outbreak)/float64(param.Trials)); math.Abs(extinction.OutbreakFraction-outbreak) > tolerance {
+			t.Errorf("%s: outbreak fraction %v; branching process %v", test.name, extinction.OutbreakFraction, outbreak)
+		}
+	}
+}
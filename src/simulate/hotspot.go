@@ -0,0 +1,112 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Who goes to a hotspot.
+type VisitorProfile string
+
+const (
+	// People go in proportion to their risk tolerance, like to the single
+	// hotspot.
+	RiskTakers VisitorProfile = ""
+	// Everyone goes alike, whatever their risk tolerance.
+	Everyone VisitorProfile = "everyone"
+	// People go in proportion to one minus their risk tolerance.
+	RiskAverse VisitorProfile = "averse"
+)
+
+// One of several hotspots. Someone with risk tolerance r goes to it on a
+// given day with probability Share * r, Share or Share * (1 - r), depending on
+// Visitors, independently of the other hotspots; so each individual has a
+// visitation probability per hotspot. Infections at the hotspot follow mass
+// action among the people there, at rate BetaR.
+type Hotspot struct {
+	// Share of the hotspot part of R0 due to this hotspot, relative to the
+	// others; ComputeBetas fills BetaR in from it.
+	Weight   float64
+	Share    float64
+	Visitors VisitorProfile `json:",omitempty"`
+	// The communities the hotspot draws visitors from, if there are
+	// several; every one of them if empty.
+	Communities []int `json:",omitempty"`
+	BetaR       float64
+}
+
+func (h Hotspot) Validate() error {
+	switch h.Visitors {
+	case RiskTakers, Everyone, RiskAverse:
+	default:
+		return fmt.Errorf("unknown hotspot visitors %q", h.Visitors)
+	}
+	if h.Weight < 0 {
+		return fmt.Errorf("hotspot weight must not be negative, got %v", h.Weight)
+	}
+	if h.Share <= 0 || h.Share > 1 {
+		return fmt.Errorf("hotspot share must be in (0, 1], got %v", h.Share)
+	}
+	return nil
+}
+
+// Probability that someone with risk tolerance risk goes to the hotspot on a
+// given day.
+func (h Hotspot) visitation(risk float64) float64 {
+	switch h.Visitors {
+	case Everyone:
+		return h.Share
+	case RiskAverse:
+		return h.Share * (1 - risk)
+	default:
+		return h.Share * risk
+	}
+}
+
+// The hotspots of a run: Hotspots, or the single hotspot everyone goes to in
+// proportion to their risk tolerance, with rate BetaR.
+func (param Parameters) hotspots() []Hotspot {
+	if len(param.Hotspots) > 0 {
+		return param.Hotspots
+	}
+	return []Hotspot{{Weight: 1, Share: 1, BetaR: param.BetaR}}
+}
+
+// Fills in each hotspot's BetaR, splitting the hotspot part of R0 between
+// them according to their weights, the way ComputeBetas sets BetaR for a
+// single hotspot. The hotspots are copied, since experiment points share
+// them.
+func (param *Parameters) computeHotspotBetas(gamma float64, n float64) {
+	total := 0.0
+	for _, h := range param.Hotspots {
+		total += h.Weight
+	}
+	hotspots := make([]Hotspot, len(param.Hotspots))
+	for k, h := range param.Hotspots {
+		h.BetaR = 0
+		// Visitation is linear in the risk tolerance.
+		mean := h.visitation(param.RiskMean)
+		if total > 0 && mean > 0 {
+			h.BetaR = gamma * (param.R0 * param.HotspotFraction * h.Weight / total / mean / mean) / n
+		}
+		hotspots[k] = h
+	}
+	param.Hotspots = hotspots
+}
+
+func validateHotspots(hotspots []Hotspot) error {
+	if len(hotspots) == 0 {
+		return nil
+	}
+	total := 0.0
+	for _, h := range hotspots {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+		total += h.Weight
+	}
+	if total == 0 {
+		return errors.New("hotspot weights must not all be zero")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package simulate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestHotspotValidate(t *testing.T) {
+	for _, hotspots := range [][]Hotspot{
+		{{Weight: 1, Share: 0}},
+		{{Weight: 1, Share: 1.5}},
+		{{Weight: -1, Share: 1}},
+		{{Weight: 0, Share: 1}, {Weight: 0, Share: 0.5}},
+		{{Weight: 1, Share: 1, Visitors: "nobody"}},
+	} {
+		param := sirParameters()
+		param.Hotspots = hotspots
+		if err := param.Validate(); err == nil {
+			t.Errorf("%+v passed validation", hotspots)
+		}
+	}
+}
+
+// ComputeBetas fills in the rates without touching the hotspots other
+// parameters share.
+func TestComputeHotspotBetas(t *testing.T) {
+	hotspots := []Hotspot{{Weight: 1, Share: 1}, {Weight: 3, Share: 0.5, Visitors: Everyone}}
+	param := sirParameters()
+	param.Hotspots = hotspots
+	param.ComputeBetas()
+	if hotspots[0].BetaR != 0 || param.BetaR != 0 {
+		t.Fatalf("ComputeBetas changed the shared hotspots or set BetaR")
+	}
+	n, R0R := float64(param.N), param.R0*param.HotspotFraction
+	want := []float64{R0R / 4 / param.RiskMean / param.RiskMean / n, R0R * 3 / 4 / 0.25 / n}
+	for k, h := range param.Hotspots {
+		if math.Abs(h.BetaR-want[k]) > 1e-12 {
+			t.Errorf("hotspot %v has BetaR %v; want %v", k, h.BetaR, want[k])
+		}
+	}
+}
+
+func TestHotspots(t *testing.T) {
+	single := sirParameters()
+	single.Extinction = StopAtCutoff
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference, RunSimulation} {
+		// A single hotspot everyone goes to in proportion to their risk is
+		// the default.
+		param := single
+		param.Hotspots = []Hotspot{{Weight: 1, Share: 1}}
+		param.ComputeBetas()
+		if got, want := run(param).Runs, run(single).Runs; !reflect.DeepEqual(got, want) {
+			t.Errorf("a single hotspot gave %+v; want %+v", got[0], want[0])
+		}
+	}
+
+	// In the differential equation, splitting the hotspot into several that
+	// each get an equal share of visits and R0 changes nothing.
+	param := single
+	param.Hotspots = []Hotspot{{Weight: 1, Share: 0.25}, {Weight: 1, Share: 0.25},
+		{Weight: 1, Share: 0.25}, {Weight: 1, Share: 0.25}}
+	param.ComputeBetas()
+	if got, want := RunDifEq(param).Runs[0].FinalR, RunDifEq(single).Runs[0].FinalR; math.Abs(got-want) > 1e-6*want {
+		t.Errorf("FinalR %v with four small hotspots; want %v", got, want)
+	}
+
+	// And a hotspot everyone goes to is just more of the community.
+	param.Hotspots = []Hotspot{{Weight: 1, Share: 1, Visitors: Everyone}}
+	param.ComputeBetas()
+	community := single
+	community.HotspotFraction = 0
+	community.ComputeBetas()
+	if got, want := RunDifEq(param).Runs[0].FinalR, RunDifEq(community).Runs[0].FinalR; math.Abs(got-want) > 1e-6*want {
+		t.Errorf("FinalR %v with a hotspot everyone goes to; want %v", got, want)
+	}
+}
+
+// A hotspot for the risk averse infects them rather than the risk takers,
+// who are then the ones left susceptible.
+func TestRiskAverseHotspot(t *testing.T) {
+	risky, averse := sirParameters(), sirParameters()
+	risky.Hotspots = []Hotspot{{Weight: 1, Share: 1}}
+	averse.Hotspots = []Hotspot{{Weight: 1, Share: 1, Visitors: RiskAverse}}
+	risky.ComputeBetas()
+	averse.ComputeBetas()
+	riskyRun, averseRun := RunDifEq(risky).Runs[0], RunDifEq(averse).Runs[0]
+	last := func(values []float64) float64 { return values[len(values)-1] }
+	if last(averseRun.SRisks) <= last(riskyRun.SRisks) {
+		t.Errorf("mean risk of the susceptible %v with a risk averse hotspot; want above %v",
+			last(averseRun.SRisks), last(riskyRun.SRisks))
+	}
+}
@@ -0,0 +1,187 @@
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Number of infectiousness classes each risk bucket of the differential and
+// difference equations is split into when infectiousness is heterogeneous and
+// continuous.
+const INFECTIOUSNESS_CLASSES = 10
+
+// The family of an AlphaDistribution.
+type AlphaShape string
+
+const (
+	LogNormalAlpha AlphaShape = "lognormal"
+	GammaAlpha     AlphaShape = "gamma"
+	// Values, drawn with probabilities proportional to Weights (or
+	// uniformly, if there are none).
+	EmpiricalAlpha AlphaShape = "empirical"
+)
+
+// The distribution of individual infectiousness: an infectious individual
+// with infectiousness alpha counts as alpha infectious contacts, both in the
+// community and at the hotspot. Mu and Std are its mean and standard
+// deviation; keeping Mu at 1 keeps R0 what ComputeBetas made it.
+type AlphaDistribution struct {
+	Shape   AlphaShape
+	Mu, Std float64
+	Values  []float64 `json:",omitempty"`
+	Weights []float64 `json:",omitempty"`
+}
+
+func (d AlphaDistribution) Validate() error {
+	switch d.Shape {
+	case LogNormalAlpha, GammaAlpha:
+		if d.Mu <= 0 || d.Std < 0 {
+			return fmt.Errorf("%s infectiousness needs a positive mean and a non-negative std, got %v and %v",
+				d.Shape, d.Mu, d.Std)
+		}
+	case EmpiricalAlpha:
+		if len(d.Values) == 0 {
+			return errors.New("empirical infectiousness needs values")
+		}
+		if len(d.Weights) > 0 && len(d.Weights) != len(d.Values) {
+			return fmt.Errorf("empirical infectiousness has %d values but %d weights", len(d.Values), len(d.Weights))
+		}
+		for _, value := range append(append([]float64{}, d.Values...), d.Weights...) {
+			if value < 0 {
+				return fmt.Errorf("empirical infectiousness values and weights must not be negative, got %v", value)
+			}
+		}
+		if len(d.Weights) > 0 && sum(d.Weights) == 0 {
+			return errors.New("empirical infectiousness weights must not all be zero")
+		}
+	default:
+		return fmt.Errorf("unknown infectiousness shape %q", d.Shape)
+	}
+	return nil
+}
+
+// Whether everyone is equally infectious.
+func (d AlphaDistribution) homogeneous() bool {
+	return d.Shape != EmpiricalAlpha && d.Std == 0
+}
+
+type continuousDist interface {
+	Rand() float64
+	Quantile(p float64) float64
+	CDF(x float64) float64
+	Mean() float64
+}
+
+// Splits a distribution on (0, inf) into n slices of equal probability and
+// returns the mean of each, so that the slices have the same mean as the
+// whole. sizeBiased has density x * p(x) / mean, which makes the partial mean
+// over a slice mean * (sizeBiased.CDF(upper) - sizeBiased.CDF(lower)).
+func sliceMeans(dist continuousDist, sizeBiased continuousDist, n int) []float64 {
+	means := make([]float64, n)
+	lower := 0.0
+	for k := range means {
+		upper := 1.0
+		if k < n-1 {
+			upper = sizeBiased.CDF(dist.Quantile(float64(k+1) / float64(n)))
+		}
+		means[k] = dist.Mean() * (upper - lower) * float64(n)
+		lower = upper
+	}
+	return means
+}
+
+// The lognormal or gamma distribution with mean Mu and std Std, drawing from
+// src if it isn't nil, along with its size-biased version.
+func (d AlphaDistribution) continuous(src *expSource) (continuousDist, continuousDist) {
+	variance := d.Std * d.Std
+	if d.Shape == LogNormalAlpha {
+		sigma2 := math.Log(1 + variance/(d.Mu*d.Mu))
+		dist := distuv.LogNormal{Mu: math.Log(d.Mu) - sigma2/2, Sigma: math.Sqrt(sigma2)}
+		if src != nil {
+			dist.Src = *src
+		}
+		return dist, distuv.LogNormal{Mu: dist.Mu + sigma2, Sigma: dist.Sigma}
+	}
+	return gammaWithMean(d.Mu, variance, src)
+}
+
+// The gamma distribution with the given mean and variance, and its
+// size-biased version.
+func gammaWithMean(mean float64, variance float64, src *expSource) (continuousDist, continuousDist) {
+	dist := distuv.Gamma{Alpha: mean * mean / variance, Beta: mean / variance}
+	if src != nil {
+		dist.Src = *src
+	}
+	return dist, distuv.Gamma{Alpha: dist.Alpha + 1, Beta: dist.Beta}
+}
+
+// The empirical weights, normalized to sum to 1.
+func (d AlphaDistribution) probabilities() []float64 {
+	probabilities := make([]float64, len(d.Values))
+	total := 0.0
+	for i := range probabilities {
+		probabilities[i] = 1
+		if len(d.Weights) > 0 {
+			probabilities[i] = d.Weights[i]
+		}
+		total += probabilities[i]
+	}
+	for i := range probabilities {
+		probabilities[i] /= total
+	}
+	return probabilities
+}
+
+// Draws n infectiousness values.
+func (d AlphaDistribution) draw(n int, rng *rand.Rand, src expSource) []float64 {
+	alphas := make([]float64, n)
+	if d.Shape == EmpiricalAlpha {
+		cumulative := d.probabilities()
+		for i := 1; i < len(cumulative); i++ {
+			cumulative[i] += cumulative[i-1]
+		}
+		for a := range alphas {
+			u := rng.Float64()
+			i := 0
+			for i < len(cumulative)-1 && u >= cumulative[i] {
+				i++
+			}
+			alphas[a] = d.Values[i]
+		}
+		return alphas
+	}
+	if d.homogeneous() {
+		for a := range alphas {
+			alphas[a] = d.Mu
+		}
+		return alphas
+	}
+	dist, _ := d.continuous(&src)
+	for a := range alphas {
+		alphas[a] = dist.Rand()
+	}
+	return alphas
+}
+
+// Splits the distribution into classes for the differential and difference
+// equations: INFECTIOUSNESS_CLASSES classes of equal probability represented
+// by their means, or the empirical values with their probabilities.
+func (d AlphaDistribution) classes() ([]float64, []float64) {
+	if d.Shape == EmpiricalAlpha {
+		return append([]float64{}, d.Values...), d.probabilities()
+	}
+	if d.homogeneous() {
+		return []float64{d.Mu}, []float64{1}
+	}
+	dist, sizeBiased := d.continuous(nil)
+	levels := sliceMeans(dist, sizeBiased, INFECTIOUSNESS_CLASSES)
+	weights := make([]float64, INFECTIOUSNESS_CLASSES)
+	for k := range weights {
+		weights[k] = 1.0 / INFECTIOUSNESS_CLASSES
+	}
+	return levels, weights
+}
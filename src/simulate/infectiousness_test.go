@@ -0,0 +1,97 @@
+package simulate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAlphaDistributionDraw(t *testing.T) {
+	rng, src := newRNG(1)
+	for _, d := range []AlphaDistribution{
+		{Shape: LogNormalAlpha, Mu: 1, Std: 2},
+		{Shape: GammaAlpha, Mu: 2, Std: 0.5},
+		{Shape: EmpiricalAlpha, Values: []float64{0, 4}, Weights: []float64{3, 1}, Mu: 1, Std: math.Sqrt(3)},
+	} {
+		alphas := d.draw(200000, rng, src)
+		mean, variance := 0.0, 0.0
+		for _, alpha := range alphas {
+			mean += alpha / float64(len(alphas))
+		}
+		for _, alpha := range alphas {
+			variance += (alpha - mean) * (alpha - mean) / float64(len(alphas))
+		}
+		if math.Abs(mean-d.Mu) > 0.05*d.Mu || math.Abs(math.Sqrt(variance)-d.Std) > 0.1*d.Std {
+			t.Errorf("%v: drew mean %v and std %v; want %v and %v", d.Shape, mean, math.Sqrt(variance), d.Mu, d.Std)
+		}
+
+		levels, weights := d.classes()
+		classMean := 0.0
+		for l := range levels {
+			classMean += levels[l] * weights[l]
+		}
+		if math.Abs(classMean-d.Mu) > 0.05*d.Mu {
+			t.Errorf("%v: classes have mean %v; want %v", d.Shape, classMean, d.Mu)
+		}
+	}
+}
+
+func TestAlphaDistributionValidate(t *testing.T) {
+	for _, d := range []AlphaDistribution{
+		{Shape: "uniform", Mu: 1},
+		{Shape: GammaAlpha, Mu: 0, Std: 1},
+		{Shape: EmpiricalAlpha},
+		{Shape: EmpiricalAlpha, Values: []float64{1, 2}, Weights: []float64{1}},
+		{Shape: EmpiricalAlpha, Values: []float64{1}, Weights: []float64{0}},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%+v passed validation", d)
+		}
+	}
+}
+
+// Everyone being exactly as infectious as usual changes nothing.
+func TestHomogeneousInfectiousness(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction, param.Trials, param.Seed, param.Record = 0.75, 20, 5, RecordNone
+	param.ComputeBetas()
+	homogeneous := param
+	homogeneous.AlphaDist = &AlphaDistribution{Shape: GammaAlpha, Mu: 1}
+	for _, run := range []func(Parameters) RunSet{RunSimulation, RunDifEq, runDifference} {
+		if !reflect.DeepEqual(run(homogeneous).Runs, run(param).Runs) {
+			t.Fatalf("homogeneous infectiousness changed the results")
+		}
+	}
+}
+
+// Individual superspreading makes outbreaks more likely to die out early,
+// but since infectiousness is independent of risk it leaves the final size
+// of a large outbreak alone.
+func TestSuperspreading(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction, param.Trials, param.Seed, param.Record = 0.75, 20, 5, RecordNone
+	param.ComputeBetas()
+	param.Trials = 400
+	param.Extinction = StopAtCutoff
+	superspreading := param
+	superspreading.AlphaDist = &AlphaDistribution{Shape: GammaAlpha, Mu: 1, Std: 3}
+
+	extinctions := func(runSet RunSet) int {
+		count := 0
+		for _, run := range runSet.Runs {
+			if !run.Truncated {
+				count++
+			}
+		}
+		return count
+	}
+	without, with := extinctions(RunSimulation(param)), extinctions(RunSimulation(superspreading))
+	if with <= without {
+		t.Errorf("%v extinctions with superspreading; %v without", with, without)
+	}
+
+	without2, with2 := RunDifEq(param).Runs[0].FinalR, RunDifEq(superspreading).Runs[0].FinalR
+	if math.Abs(with2-without2) > 0.01*without2 {
+		t.Errorf("FinalR %v with superspreading; %v without", with2, without2)
+	}
+}
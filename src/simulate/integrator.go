@@ -0,0 +1,207 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+)
+
+// How the differential equation is integrated.
+type IntegratorKind string
+
+const (
+	// Forward Euler, with a fixed step of DT.
+	EulerIntegrator IntegratorKind = ""
+	// The classic fourth order Runge-Kutta method, with a fixed step of
+	// DT * DIFEQ_SAVE_STEPS.
+	RK4Integrator IntegratorKind = "rk4"
+	// Dormand-Prince, with steps adapted to keep the local error within
+	// Tolerance.
+	RK45Integrator IntegratorKind = "rk45"
+	// Linearly implicit Euler: people leave each compartment at the rate
+	// its size at the end of the step gives, with the force of infection
+	// from the start, so that no compartment can go negative however stiff
+	// the equations are. Fixed steps of DT * DIFEQ_SAVE_STEPS.
+	ImplicitIntegrator IntegratorKind = "implicit"
+)
+
+// The local error tolerance of the adaptive integrator, relative and in
+// people, unless Parameters.Tolerance is given.
+const RK45_TOLERANCE = 1e-6
+
+// The adaptive integrator accepts any step this short, so that it can't get
+// stuck.
+const RK45_MIN_STEP = 1e-9
+
+// Advances the differential equation one step at a time.
+type integrator interface {
+	// Advances the state y by a step of at most h. Returns the step taken
+	// and the one to try next.
+	step(sys *difEqSystem, y []float64, h float64) (float64, float64)
+}
+
+// The integrator to use, with the first step to try.
+func (param Parameters) newIntegrator(size int) (integrator, float64) {
+	step := param.Step
+	if step == 0 {
+		step = DT * DIFEQ_SAVE_STEPS
+		if param.Integrator == EulerIntegrator || param.Integrator == RK45Integrator {
+			step = DT
+		}
+	}
+	switch param.Integrator {
+	case RK4Integrator:
+		return newRK4(size), step
+	case RK45Integrator:
+		tolerance := param.Tolerance
+		if tolerance == 0 {
+			tolerance = RK45_TOLERANCE
+		}
+		return newDormandPrince(size, tolerance), step
+	case ImplicitIntegrator:
+		return implicitEuler{}, step
+	default:
+		return &euler{k: make([]float64, size)}, step
+	}
+}
+
+func (param Parameters) validateIntegrator() error {
+	switch param.Integrator {
+	case EulerIntegrator, RK4Integrator, RK45Integrator, ImplicitIntegrator:
+	default:
+		return fmt.Errorf("unknown integrator %q", param.Integrator)
+	}
+	if param.Step < 0 || param.Tolerance < 0 {
+		return fmt.Errorf("integrator step and tolerance must not be negative, got %v and %v", param.Step, param.Tolerance)
+	}
+	if param.Integrator != EulerIntegrator && (param.RunType == Simulation || param.RunType == Difference || param.RunType == Analytic) {
+		return fmt.Errorf("%s runs don't take an integrator", param.RunType)
+	}
+	return nil
+}
+
+type euler struct {
+	k []float64
+}
+
+func (e *euler) step(sys *difEqSystem, y []float64, h float64) (float64, float64) {
+	sys.derivative(y, e.k)
+	for i := range y {
+		y[i] += h * e.k[i]
+	}
+	return h, h
+}
+
+type rk4 struct {
+	k1, k2, k3, k4, tmp []float64
+}
+
+func newRK4(size int) *rk4 {
+	return &rk4{
+		k1: make([]float64, size), k2: make([]float64, size), k3: make([]float64, size),
+		k4: make([]float64, size), tmp: make([]float64, size),
+	}
+}
+
+func (r *rk4) step(sys *difEqSystem, y []float64, h float64) (float64, float64) {
+	sys.derivative(y, r.k1)
+	for i := range y {
+		r.tmp[i] = y[i] + h/2*r.k1[i]
+	}
+	sys.derivative(r.tmp, r.k2)
+	for i := range y {
+		r.tmp[i] = y[i] + h/2*r.k2[i]
+	}
+	sys.derivative(r.tmp, r.k3)
+	for i := range y {
+		r.tmp[i] = y[i] + h*r.k3[i]
+	}
+	sys.derivative(r.tmp, r.k4)
+	for i := range y {
+		y[i] += h / 6 * (r.k1[i] + 2*r.k2[i] + 2*r.k3[i] + r.k4[i])
+	}
+	return h, h
+}
+
+// The Dormand-Prince tableau: the stages' coefficients, the fifth order
+// solution's weights (those of the last stage), and the difference between
+// them and the fourth order solution's, which estimates the error.
+var (
+	dpA = [][]float64{
+		{},
+		{1.0 / 5},
+		{3.0 / 40, 9.0 / 40},
+		{44.0 / 45, -56.0 / 15, 32.0 / 9},
+		{19372.0 / 6561, -25360.0 / 2187, 64448.0 / 6561, -212.0 / 729},
+		{9017.0 / 3168, -355.0 / 33, 46732.0 / 5247, 49.0 / 176, -5103.0 / 18656},
+		{35.0 / 384, 0, 500.0 / 1113, 125.0 / 192, -2187.0 / 6784, 11.0 / 84},
+	}
+	dpE = []float64{71.0 / 57600, 0, -71.0 / 16695, 71.0 / 1920, -17253.0 / 339200, 22.0 / 525, -1.0 / 40}
+)
+
+type dormandPrince struct {
+	tolerance float64
+	k         [][]float64
+	tmp, next []float64
+}
+
+func newDormandPrince(size int, tolerance float64) *dormandPrince {
+	return &dormandPrince{
+		tolerance: tolerance,
+		k:         newChain(len(dpA), size),
+		tmp:       make([]float64, size),
+		next:      make([]float64, size),
+	}
+}
+
+// Tries steps until one is within the tolerance and leaves no compartment
+// negative (beyond the tolerance, which is then rounded off), shrinking them
+// as the error estimate suggests.
+func (dp *dormandPrince) step(sys *difEqSystem, y []float64, h float64) (float64, float64) {
+	for {
+		sys.derivative(y, dp.k[0])
+		for s := 1; s < len(dpA); s++ {
+			target := dp.tmp
+			if s == len(dpA)-1 {
+				target = dp.next
+			}
+			for i := range y {
+				increment := 0.0
+				for j, a := range dpA[s] {
+					increment += a * dp.k[j][i]
+				}
+				target[i] = y[i] + h*increment
+			}
+			sys.derivative(target, dp.k[s])
+		}
+		err := 0.0
+		negative := false
+		for i := range y {
+			estimate := 0.0
+			for j, e := range dpE {
+				estimate += e * dp.k[j][i]
+			}
+			scale := dp.tolerance * (1 + math.Max(math.Abs(y[i]), math.Abs(dp.next[i])))
+			err = math.Max(err, math.Abs(h*estimate)/scale)
+			negative = negative || dp.next[i] < -dp.tolerance
+		}
+		if (err <= 1 && !negative) || h <= RK45_MIN_STEP {
+			for i := range y {
+				y[i] = math.Max(dp.next[i], 0)
+			}
+			return h, h * math.Min(5, math.Max(0.2, 0.9*math.Pow(err, -0.2)))
+		}
+		if negative {
+			h /= 2
+		} else {
+			h *= math.Max(0.2, 0.9*math.Pow(err, -0.2))
+		}
+		h = math.Max(h, RK45_MIN_STEP)
+	}
+}
+
+type implicitEuler struct{}
+
+func (implicitEuler) step(sys *difEqSystem, y []float64, h float64) (float64, float64) {
+	sys.implicitStep(y, h)
+	return h, h
+}
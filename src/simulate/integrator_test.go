@@ -0,0 +1,89 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+// The adaptive integrator's final size converges as the tolerance tightens,
+// and the fixed step integrators get close to it. Runs stop once fewer than
+// END_THRESHOLD people are infected, at a time that depends on the steps, so
+// the final sizes are compared at the same time near the end.
+func TestIntegratorConvergence(t *testing.T) {
+	base := sirParameters()
+	base.RunType, base.R0, base.HotspotFraction, base.RiskVariance = DifEq, 5, 0.9, HighVar
+	base.ComputeBetas()
+	reference := base
+	reference.Integrator, reference.Tolerance = RK45Integrator, 1e-11
+	want := RunDifEq(reference).Runs[0].Rs
+	finalR := func(param Parameters) (float64, float64) {
+		Rs := RunDifEq(param).Runs[0].Rs
+		end := len(want) - 1
+		if len(Rs) < len(want) {
+			end = len(Rs) - 1
+		}
+		return Rs[end], want[end]
+	}
+
+	previous := math.Inf(1)
+	for _, tolerance := range []float64{1e-2, 1e-4, 1e-6, 1e-8} {
+		param := base
+		param.Integrator, param.Tolerance = RK45Integrator, tolerance
+		got, want := finalR(param)
+		err := math.Abs(got - want)
+		if err > previous {
+			t.Errorf("final size off by %v with tolerance %v; %v with a looser one", err, tolerance, previous)
+		}
+		previous = err
+	}
+	if previous > 1e-6 {
+		t.Errorf("final size off by %v with the tightest tolerance", previous)
+	}
+	for _, kind := range []IntegratorKind{EulerIntegrator, RK4Integrator, ImplicitIntegrator} {
+		param := base
+		param.Integrator = kind
+		if got, want := finalR(param); math.Abs(got-want) > 0.01*want {
+			t.Errorf("final size %v with the %q integrator; want %v", got, kind, want)
+		}
+	}
+}
+
+// At a high BetaR the adaptive and implicit integrators keep every
+// compartment non-negative, and everyone in one, where forward Euler with
+// the same steps doesn't.
+func TestIntegratorsNonNegative(t *testing.T) {
+	for _, kind := range []IntegratorKind{RK45Integrator, ImplicitIntegrator} {
+		param := sirParameters()
+		param.RunType, param.R0, param.HotspotFraction, param.RiskVariance = DifEq, 40, 0.9, HighVar
+		param.Integrator = kind
+		param.ComputeBetas()
+		sys, y, _ := initialDifEq(param)
+		sys.alphaC, sys.alphaR = newInterventionSchedule(nil).rates(0, 0, sys.communities, sys.hotspots)
+		integrate, _ := param.newIntegrator(len(y))
+		h := DT * DIFEQ_SAVE_STEPS
+		for time := 0.0; time < 30; {
+			taken, next := integrate.step(sys, y, h)
+			time += taken
+			h = math.Min(next, DT*DIFEQ_SAVE_STEPS)
+			for i, v := range y {
+				if v < 0 {
+					t.Fatalf("%q integrator: entry %v of the state is %v at time %v", kind, i, v, time)
+				}
+			}
+			st := sys.state(y)
+			if total := sum(st.S) + chainSum(st.E) + chainSum(st.I) + sum(st.R); math.Abs(total-float64(param.N)) > 1e-6 {
+				t.Fatalf("%q integrator: %v people at time %v; want %v", kind, total, time, param.N)
+			}
+		}
+	}
+
+	for _, param := range []Parameters{
+		{RunType: DifEq, Integrator: "backward"},
+		{RunType: Simulation, Integrator: RK4Integrator},
+		{RunType: DifEq, Integrator: RK45Integrator, Tolerance: -1},
+	} {
+		if err := param.validateIntegrator(); err == nil {
+			t.Errorf("%+v passed validation", param)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package simulate
+
+import (
+	"fmt"
+)
+
+// What starts an intervention.
+type InterventionTrigger string
+
+const (
+	// Start at time Start.
+	TimeTrigger InterventionTrigger = ""
+	// Start Start time units after the infected fraction of the population
+	// first reaches Prevalence.
+	PrevalenceTrigger InterventionTrigger = "prevalence"
+)
+
+// A change to the infection rates that is in effect for a window of time.
+// Several interventions in effect at once multiply their scales.
+type Intervention struct {
+	Start float64
+	// How long the intervention lasts once started; 0 means until the end
+	// of the run.
+	Duration   float64
+	Trigger    InterventionTrigger `json:",omitempty"`
+	Prevalence float64             `json:",omitempty"`
+
+	// Factors BetaC and BetaR are multiplied by while the intervention is in
+	// effect; nil leaves a rate unchanged.
+	BetaCScale *float64 `json:",omitempty"`
+	BetaRScale *float64 `json:",omitempty"`
+	// Shuts the hotspot, i.e. scales BetaR by 0.
+	CloseHotspot bool `json:",omitempty"`
+}
+
+func (intervention Intervention) Validate() error {
+	switch intervention.Trigger {
+	case TimeTrigger:
+	case PrevalenceTrigger:
+		if intervention.Prevalence <= 0 || intervention.Prevalence > 1 {
+			return fmt.Errorf("intervention prevalence must be in (0, 1], got %v", intervention.Prevalence)
+		}
+	default:
+		return fmt.Errorf("unknown intervention trigger %q", intervention.Trigger)
+	}
+	if intervention.Start < 0 || intervention.Duration < 0 {
+		return fmt.Errorf("intervention start and duration must not be negative, got %v and %v",
+			intervention.Start, intervention.Duration)
+	}
+	for _, scale := range []*float64{intervention.BetaCScale, intervention.BetaRScale} {
+		if scale != nil && *scale < 0 {
+			return fmt.Errorf("intervention scales must not be negative, got %v", *scale)
+		}
+	}
+	return nil
+}
+
+// Tracks which of a run's interventions have started, and when.
+type interventionSchedule struct {
+	interventions []Intervention
+	// Start time of each intervention, or -1 if it hasn't been triggered.
+	starts []float64
+}
+
+func newInterventionSchedule(interventions []Intervention) *interventionSchedule {
+	s := &interventionSchedule{
+		interventions: interventions,
+		starts:        make([]float64, len(interventions)),
+	}
+	for i, intervention := range interventions {
+		s.starts[i] = -1
+		if intervention.Trigger == TimeTrigger {
+			s.starts[i] = intervention.Start
+		}
+	}
+	return s
+}
+
+// Returns BetaC and BetaR at time t, when prevalence is the infected fraction
+// of the population. Must be called with increasing times.
+func (s *interventionSchedule) betas(t float64, prevalence float64, betaC float64, betaR float64) (float64, float64) {
+	for i, intervention := range s.interventions {
+		if s.starts[i] < 0 && prevalence >= intervention.Prevalence {
+			s.starts[i] = t + intervention.Start
+		}
+		start := s.starts[i]
+		if start < 0 || t < start || (intervention.Duration > 0 && t >= start+intervention.Duration) {
+			continue
+		}
+		if intervention.BetaCScale != nil {
+			betaC *= *intervention.BetaCScale
+		}
+		if intervention.BetaRScale != nil {
+			betaR *= *intervention.BetaRScale
+		}
+		if intervention.CloseHotspot {
+			betaR = 0
+		}
+	}
+	return betaC, betaR
+}
+
+// Returns the BetaC of each community and the BetaR of each hotspot at time
+// t, like betas.
+func (s *interventionSchedule) rates(t float64, prevalence float64, communities []Community, hotspots []Hotspot) ([]float64, []float64) {
+	betaC := make([]float64, len(communities))
+	for i, c := range communities {
+		betaC[i], _ = s.betas(t, prevalence, c.BetaC, 0)
+	}
+	betaR := make([]float64, len(hotspots))
+	for k, h := range hotspots {
+		_, betaR[k] = s.betas(t, prevalence, 0, h.BetaR)
+	}
+	return betaC, betaR
+}
+
+// The start time of every intervention that was triggered, or -1 for those
+// that weren't; nil if there are no interventions.
+func (s *interventionSchedule) startTimes() []float64 {
+	if len(s.interventions) == 0 {
+		return nil
+	}
+	return append([]float64{}, s.starts...)
+}
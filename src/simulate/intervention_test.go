@@ -0,0 +1,76 @@
+package simulate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func scale(f float64) *float64 {
+	return &f
+}
+
+func TestInterventionSchedule(t *testing.T) {
+	schedule := newInterventionSchedule([]Intervention{
+		{Start: 2, Duration: 3, BetaCScale: scale(0.5)},
+		{Start: 1, Trigger: PrevalenceTrigger, Prevalence: 0.1, CloseHotspot: true},
+		{Start: 4, BetaRScale: scale(0.25)},
+	})
+	for _, test := range []struct {
+		t, prevalence float64
+		betaC, betaR  float64
+	}{
+		{0, 0.05, 1, 1},
+		{1, 0.1, 1, 1},
+		{2, 0.2, 0.5, 0},
+		{4, 0.2, 0.5, 0},
+		{5, 0.05, 1, 0},
+	} {
+		betaC, betaR := schedule.betas(test.t, test.prevalence, 1, 1)
+		if betaC != test.betaC || betaR != test.betaR {
+			t.Fatalf("at t=%v: betas %v, %v; want %v, %v", test.t, betaC, betaR, test.betaC, test.betaR)
+		}
+	}
+	if got, want := schedule.startTimes(), []float64{2, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("start times %v; want %v", got, want)
+	}
+}
+
+func TestInterventionValidate(t *testing.T) {
+	for _, intervention := range []Intervention{
+		{Trigger: "never"},
+		{Trigger: PrevalenceTrigger},
+		{Duration: -1},
+		{BetaCScale: scale(-0.5)},
+	} {
+		if err := intervention.Validate(); err == nil {
+			t.Errorf("%+v passed validation", intervention)
+		}
+	}
+}
+
+// Closing the hotspot for the whole run is the same as not having one, in
+// every model.
+func TestCloseHotspot(t *testing.T) {
+	param := Parameters{
+		N: 1000, DiseaseLength: 1, R0: 2, HotspotFraction: 0.5, RiskMean: 0.25,
+		RiskVariance: MediumVar, Trials: 5, Seed: 3, Extinction: FullRun,
+	}
+	param.ComputeBetas()
+	closed := param
+	closed.Interventions = []Intervention{{CloseHotspot: true}}
+	open := param
+	open.BetaR = 0
+
+	for _, run := range []func(Parameters) RunSet{RunSimulation, RunDifEq, runDifference} {
+		got, want := run(closed).Runs, run(open).Runs
+		for i := range got {
+			if !reflect.DeepEqual(got[i].InterventionStarts, []float64{0}) {
+				t.Fatalf("intervention started at %v", got[i].InterventionStarts)
+			}
+			got[i].InterventionStarts = nil
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("closing the hotspot differs from BetaR = 0")
+		}
+	}
+}
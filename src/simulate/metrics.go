@@ -4,31 +4,53 @@ package simulate
 // Outbreak occurs if at least 5% are infected.
 const OUTBREAK_THRESHOLD = 0.05
 
-// Assumes that we exceed outbreak threshold and then go back below it
-// once each.
-func computeOutbreakDuration(Is []float64, param Parameters) float64 {
-
-	duration := 0.0
-	outbreakStarted := false
+// A period during which at least OUTBREAK_THRESHOLD of the population is
+// infectious. An epidemic can have several, e.g. with waning immunity or
+// interventions that are lifted.
+type Wave struct {
+	// When the wave first reaches the threshold, and how long it stays
+	// there.
+	Start    float64
+	Duration float64
+	// Size and time of the wave's peak.
+	Peak     float64
+	PeakTime float64
+}
 
-	var outbreakThreshold float64 = OUTBREAK_THRESHOLD * float64(param.N)
-	for _, infected := range Is {
-		// Count the number of times we meet or exceed the threshold until
-		// dipping below.
-		if float64(infected) >= outbreakThreshold {
-			outbreakStarted = true
-			duration += 1.0
-		} else if outbreakStarted {
-			break
+// Splits the trajectory into waves. The interval is the time between the
+// entries of Is, which depends on the model that saved them.
+func computeWaves(Is []float64, param Parameters, interval float64) []Wave {
+	outbreakThreshold := OUTBREAK_THRESHOLD * float64(param.N)
+	var waves []Wave
+	var wave *Wave
+	for t, infected := range Is {
+		if infected < outbreakThreshold {
+			wave = nil
+			continue
+		}
+		if wave == nil {
+			waves = append(waves, Wave{Start: float64(t) * interval})
+			wave = &waves[len(waves)-1]
+		}
+		wave.Duration += interval
+		if infected > wave.Peak {
+			wave.Peak = infected
+			wave.PeakTime = float64(t) * interval
 		}
 	}
-	if param.RunType == DifEq {
-		return duration * DT
+	return waves
+}
+
+// The duration of the first wave.
+func computeOutbreakDuration(Is []float64, param Parameters, interval float64) float64 {
+	waves := computeWaves(Is, param, interval)
+	if len(waves) == 0 {
+		return 0
 	}
-	return duration
+	return waves[0].Duration
 }
 
-func computePeakTime(Is []float64, param Parameters) float64 {
+func computePeakTime(Is []float64, interval float64) float64 {
 	peakTime := 0.0
 	peakInfected := 0.0
 	for t, infected := range Is {
@@ -38,8 +60,5 @@ func computePeakTime(Is []float64, param Parameters) float64 {
 			peakInfected = infected
 		}
 	}
-	if param.RunType == DifEq {
-		return peakTime * DT
-	}
-	return peakTime
+	return peakTime * interval
 }
@@ -1,6 +1,8 @@
 package simulate
 
 import (
+	"math"
+	"reflect"
 	"testing"
 )
 
@@ -20,7 +22,7 @@ func TestOutbreakDuration(t *testing.T) {
 		// Test that if we end before dropping below the threshold we properly terminate
 		{Is: []float64{100}, want: 1},
 	} {
-		got := computeOutbreakDuration(test.Is, params)
+		got := computeOutbreakDuration(test.Is, params, 1)
 		if got != test.want {
 			t.Fatalf("computeOutbreakDuration(%v) = %v; want %v",
 				test.Is, got, test.want)
@@ -31,8 +33,6 @@ func TestOutbreakDuration(t *testing.T) {
 
 func TestPeakTime(t *testing.T) {
 
-	params := Parameters{N: 1000}
-
 	for _, test := range []struct {
 		Is   []float64
 		want float64
@@ -45,7 +45,7 @@ func TestPeakTime(t *testing.T) {
 		// Test that if we end before dropping below the threshold we properly terminate
 		{Is: []float64{1, 100}, want: 1},
 	} {
-		got := computePeakTime(test.Is, params)
+		got := computePeakTime(test.Is, 1)
 		if got != test.want {
 			t.Fatalf("computePeakTime(%v) = %v; want %v",
 				test.Is, got, test.want)
@@ -53,3 +53,53 @@ func TestPeakTime(t *testing.T) {
 
 	}
 }
+
+func TestWaves(t *testing.T) {
+	params := Parameters{N: 1000}
+	got := computeWaves([]float64{1, 60, 80, 10, 0, 50, 200, 100, 5}, params, 1)
+	want := []Wave{
+		{Start: 1, Duration: 2, Peak: 80, PeakTime: 2},
+		{Start: 5, Duration: 3, Peak: 200, PeakTime: 6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("computeWaves = %+v; want %+v", got, want)
+	}
+
+	// The differential equation only saves every DIFEQ_SAVE_STEPS steps,
+	// whatever the RunType says.
+	run := RunDifEq(sirParameters()).Runs[0]
+	if got, want := run.Ts[1]-run.Ts[0], DT*DIFEQ_SAVE_STEPS; math.Abs(got-want) > 1e-9 {
+		t.Fatalf("RunDifEq saved every %v; want %v", got, want)
+	}
+	peak := 0
+	for t, infected := range run.Is {
+		if infected > run.Is[peak] {
+			peak = t
+		}
+	}
+	if math.Abs(run.PeakTime-run.Ts[peak]) > 1e-9 {
+		t.Fatalf("RunDifEq PeakTime = %v; want %v", run.PeakTime, run.Ts[peak])
+	}
+}
+
+// With waning immunity the infection becomes endemic, so the runs stop at
+// MaxTime having infected people more than once.
+func TestWaningImmunity(t *testing.T) {
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference, RunSimulation} {
+		param := sirParameters()
+		param.N = 10000
+		param.WaningRate = 0.02
+		if err := param.Validate(); err == nil {
+			t.Errorf("waning immunity without a max time passed validation")
+		}
+		param.MaxTime = 1000
+		param.ComputeBetas()
+		got := run(param).Runs[0]
+		if !got.Truncated {
+			t.Errorf("the infection died out with waning immunity")
+		}
+		if got.FinalR <= float64(param.N) {
+			t.Errorf("FinalR %v; want more than everyone", got.FinalR)
+		}
+	}
+}
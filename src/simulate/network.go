@@ -0,0 +1,328 @@
+package simulate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How the community contact network of a simulation is built.
+type NetworkKind string
+
+const (
+	// Every pair of individuals is in contact with the same probability.
+	ErdosRenyi NetworkKind = "erdos-renyi"
+	// Degrees are drawn from Degrees, and contacts are paired at random.
+	ConfigurationModel NetworkKind = "configuration"
+	// A ring lattice whose edges are each rewired with probability Rewiring.
+	WattsStrogatz NetworkKind = "watts-strogatz"
+	// Preferential attachment: each individual joins with MeanDegree/2
+	// contacts, chosen in proportion to degree.
+	BarabasiAlbert NetworkKind = "barabasi-albert"
+	// The edges listed in File.
+	EdgeList NetworkKind = "edgelist"
+)
+
+// Community contacts of the simulation along a network rather than well
+// mixed; the hotspot stays well mixed. A random network is drawn for every
+// trial. Each edge with an infectious end passes the infection on with
+// probability BetaC * N / (mean degree) a day, so that the community part of
+// R0 is the same on average as with well mixed contacts.
+type Network struct {
+	Kind       NetworkKind
+	MeanDegree float64 `json:",omitempty"`
+	// The degrees the configuration model draws from, uniformly.
+	Degrees []int `json:",omitempty"`
+	// The fraction of edges the Watts-Strogatz model rewires.
+	Rewiring float64 `json:",omitempty"`
+	// A file with an edge per line: two individuals numbered from 0,
+	// separated by white space. Lines starting with # are skipped.
+	File string `json:",omitempty"`
+}
+
+func (network Network) Validate() error {
+	switch network.Kind {
+	case ErdosRenyi, WattsStrogatz, BarabasiAlbert:
+		if network.MeanDegree <= 0 {
+			return fmt.Errorf("%s networks need a positive mean degree, got %v", network.Kind, network.MeanDegree)
+		}
+		if network.Kind != ErdosRenyi && int(network.MeanDegree)/2 < 1 {
+			return fmt.Errorf("%s networks need a mean degree of at least 2, got %v", network.Kind, network.MeanDegree)
+		}
+	case ConfigurationModel:
+		if len(network.Degrees) == 0 {
+			return errors.New("configuration model networks need degrees")
+		}
+		for _, degree := range network.Degrees {
+			if degree < 0 {
+				return fmt.Errorf("network degrees must not be negative, got %d", degree)
+			}
+		}
+	case EdgeList:
+		if _, err := os.Stat(network.File); err != nil {
+			return fmt.Errorf("edge list: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown network kind %q", network.Kind)
+	}
+	if network.Rewiring < 0 || network.Rewiring > 1 {
+		return fmt.Errorf("network rewiring must be in [0, 1], got %v", network.Rewiring)
+	}
+	return nil
+}
+
+// An undirected graph in compressed sparse row form: the neighbours of agent
+// a are neighbours[offsets[a]:offsets[a+1]].
+type graph struct {
+	offsets    []int32
+	neighbours []int32
+}
+
+func (g *graph) neighboursOf(a int32) []int32 {
+	return g.neighbours[g.offsets[a]:g.offsets[a+1]]
+}
+
+func (g *graph) meanDegree() float64 {
+	n := len(g.offsets) - 1
+	if n == 0 {
+		return 0
+	}
+	return float64(len(g.neighbours)) / float64(n)
+}
+
+// Builds the graph on n agents with the given edges, dropping self loops and
+// repeated edges.
+func newGraph(n int, edges [][2]int32) *graph {
+	adjacency := make([][]int32, n)
+	for _, edge := range edges {
+		if edge[0] == edge[1] {
+			continue
+		}
+		adjacency[edge[0]] = append(adjacency[edge[0]], edge[1])
+		adjacency[edge[1]] = append(adjacency[edge[1]], edge[0])
+	}
+	g := &graph{offsets: make([]int32, n+1), neighbours: make([]int32, 0, 2*len(edges))}
+	for a, neighbours := range adjacency {
+		sort.Slice(neighbours, func(i, j int) bool { return neighbours[i] < neighbours[j] })
+		for i, b := range neighbours {
+			if i == 0 || b != neighbours[i-1] {
+				g.neighbours = append(g.neighbours, b)
+			}
+		}
+		g.offsets[a+1] = int32(len(g.neighbours))
+	}
+	return g
+}
+
+// Builds the network for a population of n, drawing from rng. Edge lists are
+// read from their file.
+func (network Network) build(n int, rng *rand.Rand) (*graph, error) {
+	switch network.Kind {
+	case ErdosRenyi:
+		return newGraph(n, erdosRenyiEdges(n, network.MeanDegree, rng)), nil
+	case ConfigurationModel:
+		return newGraph(n, configurationEdges(n, network.Degrees, rng)), nil
+	case WattsStrogatz:
+		return newGraph(n, wattsStrogatzEdges(n, int(network.MeanDegree)/2, network.Rewiring, rng)), nil
+	case BarabasiAlbert:
+		return newGraph(n, barabasiAlbertEdges(n, int(network.MeanDegree)/2, rng)), nil
+	case EdgeList:
+		edges, err := readEdgeList(network.File, n)
+		if err != nil {
+			return nil, err
+		}
+		return newGraph(n, edges), nil
+	}
+	return nil, fmt.Errorf("unknown network kind %q", network.Kind)
+}
+
+// G(n, p) with p = meanDegree / (n - 1), skipping over the pairs that aren't
+// edges (Batagelj and Brandes, 2005).
+func erdosRenyiEdges(n int, meanDegree float64, rng *rand.Rand) [][2]int32 {
+	edges := [][2]int32{}
+	if n < 2 {
+		return edges
+	}
+	p := meanDegree / float64(n-1)
+	if p >= 1 {
+		for v := 1; v < n; v++ {
+			for w := 0; w < v; w++ {
+				edges = append(edges, [2]int32{int32(v), int32(w)})
+			}
+		}
+		return edges
+	}
+	logQ := math.Log(1 - p)
+	v, w := 1, -1
+	for v < n {
+		w += 1 + int(math.Log(1-rng.Float64())/logQ)
+		for w >= v && v < n {
+			w -= v
+			v++
+		}
+		if v < n {
+			edges = append(edges, [2]int32{int32(v), int32(w)})
+		}
+	}
+	return edges
+}
+
+// Pairs up stubs at random, with each agent's degree drawn from degrees; an
+// agent gets an extra stub if the total is odd.
+func configurationEdges(n int, degrees []int, rng *rand.Rand) [][2]int32 {
+	stubs := []int32{}
+	for a := 0; a < n; a++ {
+		for d := degrees[rng.IntN(len(degrees))]; d > 0; d-- {
+			stubs = append(stubs, int32(a))
+		}
+	}
+	if len(stubs)%2 == 1 {
+		stubs = append(stubs, int32(rng.IntN(n)))
+	}
+	rng.Shuffle(len(stubs), func(i, j int) { stubs[i], stubs[j] = stubs[j], stubs[i] })
+	edges := make([][2]int32, 0, len(stubs)/2)
+	for i := 0; i+1 < len(stubs); i += 2 {
+		edges = append(edges, [2]int32{stubs[i], stubs[i+1]})
+	}
+	return edges
+}
+
+// A ring where everyone is in contact with the k nearest agents on either
+// side, after which each edge is rewired to a random agent with probability
+// rewiring, unless that would repeat an edge.
+func wattsStrogatzEdges(n int, k int, rewiring float64, rng *rand.Rand) [][2]int32 {
+	key := func(a, b int32) uint64 {
+		if a > b {
+			a, b = b, a
+		}
+		return uint64(a)<<32 | uint64(b)
+	}
+	present := map[uint64]bool{}
+	edges := make([][2]int32, 0, n*k)
+	for a := 0; a < n; a++ {
+		for j := 1; j <= k; j++ {
+			edge := [2]int32{int32(a), int32((a + j) % n)}
+			edges = append(edges, edge)
+			present[key(edge[0], edge[1])] = true
+		}
+	}
+	for i, edge := range edges {
+		if rng.Float64() >= rewiring {
+			continue
+		}
+		b := int32(rng.IntN(n))
+		if b == edge[0] || present[key(edge[0], b)] {
+			continue
+		}
+		delete(present, key(edge[0], edge[1]))
+		present[key(edge[0], b)] = true
+		edges[i][1] = b
+	}
+	return edges
+}
+
+// Starts from m+1 agents in contact with each other, then adds the others
+// one by one, each with m contacts chosen in proportion to degree.
+func barabasiAlbertEdges(n int, m int, rng *rand.Rand) [][2]int32 {
+	edges := [][2]int32{}
+	// Every agent appears here once per edge, so a uniform pick is
+	// proportional to degree.
+	ends := []int32{}
+	for v := 1; v <= m && v < n; v++ {
+		for w := 0; w < v; w++ {
+			edges = append(edges, [2]int32{int32(v), int32(w)})
+			ends = append(ends, int32(v), int32(w))
+		}
+	}
+	chosen := make(map[int32]bool, m)
+	for v := m + 1; v < n; v++ {
+		for w := range chosen {
+			delete(chosen, w)
+		}
+		for len(chosen) < m {
+			chosen[ends[rng.IntN(len(ends))]] = true
+		}
+		// Sorted, so that the edges don't depend on map order.
+		targets := make([]int32, 0, m)
+		for w := range chosen {
+			targets = append(targets, w)
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+		for _, w := range targets {
+			edges = append(edges, [2]int32{int32(v), w})
+			ends = append(ends, int32(v), w)
+		}
+	}
+	return edges
+}
+
+// Reads the edges in fileName between agents 0 to n - 1.
+func readEdgeList(fileName string, n int) ([][2]int32, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	edges := [][2]int32{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: want two agents, got %q", fileName, line, scanner.Text())
+		}
+		var edge [2]int32
+		for i := range edge {
+			a, err := strconv.Atoi(fields[i])
+			if err != nil || a < 0 || a >= n {
+				return nil, fmt.Errorf("%s:%d: want agents from 0 to %d, got %q", fileName, line, n-1, fields[i])
+			}
+			edge[i] = int32(a)
+		}
+		edges = append(edges, edge)
+	}
+	return edges, scanner.Err()
+}
+
+// Community spread along the network: every edge between an infectious and
+// a susceptible agent passes the infection on with probability
+// 1 - (1 - beta)^(contacts of the infectious agent).
+func (p *population) spreadNetwork(beta float64) int {
+	if beta > 1 {
+		beta = 1
+	}
+	newInfections := 0
+	// Only the agents infectious at the start of the step, before any of
+	// this step's infections are appended.
+	infected := p.infected
+	for _, a := range infected {
+		if p.daysInfected[a] <= p.latentPeriod(a) {
+			continue
+		}
+		probability := infectionProbability(beta, p.contacts(a))
+		for _, b := range p.network.neighboursOf(a) {
+			if p.status[b] == SUSCEPTIBLE && p.rng.Float64() < probability {
+				p.infect(b)
+				newInfections++
+			}
+		}
+	}
+	if newInfections > 0 {
+		stillSusceptible := p.susceptible[:0]
+		for _, a := range p.susceptible {
+			if p.status[a] == SUSCEPTIBLE {
+				stillSusceptible = append(stillSusceptible, a)
+			}
+		}
+		p.susceptible = stillSusceptible
+	}
+	return newInfections
+}
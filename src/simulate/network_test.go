@@ -0,0 +1,126 @@
+package simulate
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Checks that g is undirected, without self loops or repeated edges.
+func checkGraph(t *testing.T, name string, g *graph) {
+	t.Helper()
+	n := int32(len(g.offsets) - 1)
+	for a := int32(0); a < n; a++ {
+		neighbours := g.neighboursOf(a)
+		for i, b := range neighbours {
+			if b == a || (i > 0 && b <= neighbours[i-1]) {
+				t.Fatalf("%s: agent %v has neighbours %v", name, a, neighbours)
+			}
+			found := false
+			for _, c := range g.neighboursOf(b) {
+				found = found || c == a
+			}
+			if !found {
+				t.Fatalf("%s: %v is a neighbour of %v but not the other way around", name, b, a)
+			}
+		}
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	rng, _ := newRNG(3)
+	for _, test := range []struct {
+		network Network
+		degree  float64
+	}{
+		{Network{Kind: ErdosRenyi, MeanDegree: 6}, 6},
+		{Network{Kind: ConfigurationModel, Degrees: []int{2, 10}}, 6},
+		{Network{Kind: WattsStrogatz, MeanDegree: 6}, 6},
+		{Network{Kind: WattsStrogatz, MeanDegree: 6, Rewiring: 0.2}, 6},
+		{Network{Kind: BarabasiAlbert, MeanDegree: 6}, 6},
+	} {
+		g, err := test.network.build(5000, rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGraph(t, string(test.network.Kind), g)
+		if degree := g.meanDegree(); math.Abs(degree-test.degree) > 0.1*test.degree {
+			t.Errorf("%+v has mean degree %v; want %v", test.network, degree, test.degree)
+		}
+	}
+
+	// Without rewiring, Watts-Strogatz is a ring lattice.
+	g, _ := Network{Kind: WattsStrogatz, MeanDegree: 4}.build(10, rng)
+	if got, want := g.neighboursOf(0), []int32{1, 2, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ring lattice neighbours %v; want %v", got, want)
+	}
+}
+
+func TestReadEdgeList(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "edges.txt")
+	os.WriteFile(fileName, []byte("# a triangle and a pendant\n0 1\n1 2\n2 0\n\n2 3\n3 2\n"), 0644)
+	g, err := Network{Kind: EdgeList, File: fileName}.build(5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGraph(t, "edge list", g)
+	if got, want := g.neighboursOf(2), []int32{0, 1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("neighbours of 2 are %v; want %v", got, want)
+	}
+	if len(g.neighboursOf(4)) != 0 {
+		t.Errorf("agent 4 has neighbours %v", g.neighboursOf(4))
+	}
+	if _, err := (Network{Kind: EdgeList, File: fileName}).build(3, nil); err == nil {
+		t.Errorf("read agent 3 into a population of 3")
+	}
+
+	// Validate reads the edge list, so a malformed one fails before any run.
+	param := sirParameters()
+	param.N, param.Network = 3, &Network{Kind: EdgeList, File: fileName}
+	if err := param.Validate(); err == nil {
+		t.Errorf("validated agent 3 in a population of 3")
+	}
+}
+
+func TestNetworkSpread(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction = 0
+	param.ComputeBetas()
+	param.Trials = 200
+
+	// Nobody is infected in the community without contacts.
+	fileName := filepath.Join(t.TempDir(), "edges.txt")
+	os.WriteFile(fileName, []byte{}, 0644)
+	param.Network = &Network{Kind: EdgeList, File: fileName}
+	if err := param.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, run := range RunSimulation(param).Runs {
+		if run.FinalR != INITIAL_INFECTED {
+			t.Fatalf("FinalR %v without contacts; want %v", run.FinalR, INITIAL_INFECTED)
+		}
+	}
+
+	// Clustered contacts slow the spread down compared to random ones with
+	// the same mean degree.
+	meanFinalR := func(network Network) float64 {
+		param.Network = &network
+		total := 0.0
+		for _, run := range RunSimulation(param).Runs {
+			total += run.FinalR / float64(param.Trials)
+		}
+		return total
+	}
+	random := meanFinalR(Network{Kind: ErdosRenyi, MeanDegree: 10})
+	lattice := meanFinalR(Network{Kind: WattsStrogatz, MeanDegree: 10})
+	if random < 0.3*float64(param.N) || lattice >= random {
+		t.Errorf("mean FinalR %v on a random network and %v on a lattice", random, lattice)
+	}
+
+	param.RunType = DifEq
+	if err := param.Validate(); err == nil {
+		t.Errorf("a differential equation with a network passed validation")
+	}
+}
@@ -1,21 +1,15 @@
 package simulate
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 type RiskDistribution struct {
 	// riskyness distribution parameters:
 	A, B float64
 }
 
-type AlphaDistribution struct {
-	Mu, Std float64
-}
-
-type Intervention struct {
-	Start    float64
-	Duration float64
-}
-
 type RunType string
 
 const (
@@ -23,6 +17,38 @@ const (
 	Simulation RunType = "simulation"
 	DifEq      RunType = "difeq"
 	Difference RunType = "difference"
+	// The differential equation's final size, threshold and growth rate,
+	// solved for directly (see RunAnalytic).
+	Analytic RunType = "analytic"
+)
+
+// How much of each simulation trial's trajectory to save in its Run.
+type RecordLevel string
+
+const (
+	RecordNone RecordLevel = ""
+	// Ts, Is and Rs:
+	RecordCompartments RecordLevel = "compartments"
+	// Also RiskyInfections, CommunityInfections, IRisks and SRisks:
+	RecordAll RecordLevel = "all"
+)
+
+// When to cut a simulation trial short. Trials always end once no-one is
+// infected.
+type ExtinctionMode string
+
+const (
+	// Stop once ExtinctionCutoff people have been infected in total, since
+	// we know the outbreak didn't go extinct. FinalR, MaxI, Duration and
+	// PeakTime then only describe the start of the outbreak.
+	StopAtCutoff ExtinctionMode = ""
+	// Stop once ExtinctionCutoff people are infected at the same time. A
+	// cumulative count can be reached by a long chain of small clusters in a
+	// subcritical run; prevalence that high makes a later extinction
+	// vanishingly unlikely.
+	StopAtDecided ExtinctionMode = "decided"
+	// Never stop early, so every statistic describes the full outbreak.
+	FullRun ExtinctionMode = "full"
 )
 
 type RiskVariance string
@@ -58,17 +84,219 @@ type Parameters struct {
 	BetaC, BetaR float64
 	// disease lasts for this long before the individual recovers:
 	DiseaseLength int
+	// time between being infected and becoming infectious (0 for none):
+	LatentLength int `json:",omitempty"`
+	// number of stages the latent and infectious periods are split into,
+	// each left at a constant rate so that the periods are Erlang distributed
+	// with means LatentLength and DiseaseLength. 0 keeps the classic periods:
+	// a single exponential stage in the differential equation, and exactly
+	// that many days in the simulation and the difference equation:
+	LatentStages     int `json:",omitempty"`
+	InfectiousStages int `json:",omitempty"`
 	// if not nil, contains information to construct riskyness distribution:
 	RiskDist *RiskDistribution
+	// if not nil, individuals differ in how infectious they are:
+	AlphaDist *AlphaDistribution `json:",omitempty"`
 
 	// More meta/computed stuff:
 	RunType RunType
 	R0      float64
-	// RiskynessMean     float64
-	// RiskynessVariance float64 // This could either be an enum (HIGH, MEDIUM, LOW), or need to standardize them
+	// fraction of R0 due to the hotspot:
+	HotspotFraction float64
+	// describe the riskyness distribution when it was built with RiskDist:
+	RiskMean     float64
+	RiskVariance RiskVariance
 
 	// Number of identical simulations to run:
 	Trials int
+	// When to stop simulation trials early, and the number of infections
+	// that decides it (EXTINCTION_CUTOFF if 0):
+	Extinction       ExtinctionMode `json:",omitempty"`
+	ExtinctionCutoff int            `json:",omitempty"`
+	// Whether simulations also work out the branching process's probability
+	// that their outbreak dies out (see RunSet.Extinction), which takes
+	// seconds with many classes:
+	PredictExtinction bool `json:",omitempty"`
+	// What to save of each simulation trial besides the summary statistics
+	// (the differential equation always saves its trajectory):
+	Record RecordLevel `json:",omitempty"`
+	// Seeds every random draw of a simulation. Trial i draws from its own
+	// stream, seeded with SubSeed(Seed, i):
+	Seed uint64
+
+	// Changes to BetaC and BetaR over the course of a run, honoured by every
+	// run type. Time is in days:
+	Interventions []Intervention `json:",omitempty"`
+	// If set, people go to the hotspot less as prevalence rises:
+	Caution *Caution `json:",omitempty"`
+	// If set, these replace the single hotspot, and BetaR is 0. The hotspot
+	// part of R0 is split between them:
+	Hotspots []Hotspot `json:",omitempty"`
+	// If set, community contacts in the simulation follow this network:
+	Network *Network `json:",omitempty"`
+	// If set, the population is split into these communities, which only
+	// meet at the hotspots, and BetaC and BetaR are 0. The initial
+	// infections are in the first community. Not supported by the
+	// difference equation:
+	Communities []Community `json:",omitempty"`
+	// Vaccination campaigns, and pre-existing immunity:
+	Vaccinations []Vaccination `json:",omitempty"`
+	// Where the epidemic starts, if not with INITIAL_INFECTED random people:
+	Seeding *Seeding `json:",omitempty"`
+	// How the differential equation is integrated, with the fixed step (or
+	// the first step of the adaptive integrator) and its error tolerance:
+	Integrator IntegratorKind `json:",omitempty"`
+	Step       float64        `json:",omitempty"`
+	Tolerance  float64        `json:",omitempty"`
+	// The number of risk buckets of the differential and difference
+	// equations (BUCKETS if 0), and how they are placed:
+	Buckets        int            `json:",omitempty"`
+	Discretisation Discretisation `json:",omitempty"`
+
+	// Rate at which the recovered lose their immunity and become susceptible
+	// again, per day (in the simulation and the difference equation, the
+	// fraction of the recovered that lose it each day):
+	WaningRate float64 `json:",omitempty"`
+	// Runs stop after this many days (0 for no limit). Required with
+	// waning immunity, which can keep the infection going for ever:
+	MaxTime float64 `json:",omitempty"`
+}
+
+// Fills in BetaC, BetaR and RiskDist from R0, HotspotFraction, RiskMean,
+// RiskVariance, DiseaseLength and N. R0 is split between the community and
+// the hotspot according to HotspotFraction.
+func (param *Parameters) ComputeBetas() {
+	gamma := 1.0 / float64(param.DiseaseLength)
+	n := float64(param.N)
+
+	param.BetaC = gamma * (param.R0 * (1 - param.HotspotFraction)) / n
+	if param.RiskMean == 0 {
+		param.BetaR = 0
+	} else {
+		param.BetaR = gamma * (param.R0 * param.HotspotFraction / param.RiskMean / param.RiskMean) / n
+	}
+	param.RiskDist = RiskDist(param.RiskMean, param.RiskVariance)
+	if len(param.Communities) > 0 {
+		param.computeCommunityBetas(gamma)
+	} else if len(param.Hotspots) > 0 {
+		param.BetaR = 0
+		param.computeHotspotBetas(gamma, n)
+	}
+}
+
+// Checks the settings that aren't derived from the swept values.
+func (param Parameters) Validate() error {
+	switch param.Record {
+	case RecordNone, RecordCompartments, RecordAll:
+	default:
+		return fmt.Errorf("unknown record level %q", param.Record)
+	}
+	switch param.Extinction {
+	case StopAtCutoff, StopAtDecided, FullRun:
+	default:
+		return fmt.Errorf("unknown extinction mode %q", param.Extinction)
+	}
+	if param.ExtinctionCutoff < 0 {
+		return fmt.Errorf("extinction cutoff must not be negative, got %d", param.ExtinctionCutoff)
+	}
+	if err := param.validateIntegrator(); err != nil {
+		return err
+	}
+	if err := param.validateDiscretisation(); err != nil {
+		return err
+	}
+	if err := param.validateAnalytic(); err != nil {
+		return err
+	}
+	if err := param.validateStages(); err != nil {
+		return err
+	}
+	if param.WaningRate < 0 || param.MaxTime < 0 {
+		return fmt.Errorf("waning rate and max time must not be negative, got %v and %v", param.WaningRate, param.MaxTime)
+	}
+	if param.WaningRate > 0 && param.MaxTime == 0 {
+		return fmt.Errorf("waning immunity needs a max time")
+	}
+	if param.WaningRate > 1 && (param.RunType == Simulation || param.RunType == Difference) {
+		return fmt.Errorf("%s runs need a waning rate of at most 1, got %v", param.RunType, param.WaningRate)
+	}
+	for _, intervention := range param.Interventions {
+		if err := intervention.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateHotspots(param.Hotspots); err != nil {
+		return err
+	}
+	if err := validateCommunities(param); err != nil {
+		return err
+	}
+	if param.Seeding != nil {
+		if err := param.Seeding.Validate(); err != nil {
+			return err
+		}
+		if param.Seeding.ImportationRate > 0 && param.MaxTime == 0 {
+			return fmt.Errorf("importation needs a max time")
+		}
+	}
+	for _, vaccination := range param.Vaccinations {
+		if err := vaccination.Validate(); err != nil {
+			return err
+		}
+	}
+	if param.Network != nil {
+		if param.RunType == DifEq || param.RunType == Difference || param.RunType == Analytic {
+			return fmt.Errorf("%s runs don't support contact networks", param.RunType)
+		}
+		if err := param.Network.Validate(); err != nil {
+			return err
+		}
+		// Reads the edge list now, so that a malformed one fails before any
+		// run. It can only be checked against a population.
+		if param.Network.Kind == EdgeList && param.N > 0 {
+			if _, err := readEdgeList(param.Network.File, param.N); err != nil {
+				return fmt.Errorf("edge list: %v", err)
+			}
+		}
+	}
+	if param.Caution != nil {
+		if err := param.Caution.Validate(); err != nil {
+			return err
+		}
+	}
+	if param.AlphaDist != nil {
+		if err := param.AlphaDist.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtinctionCutoff, or EXTINCTION_CUTOFF if it isn't set.
+func (param Parameters) extinctionCutoff() int {
+	if param.ExtinctionCutoff == 0 {
+		return EXTINCTION_CUTOFF
+	}
+	return param.ExtinctionCutoff
+}
+
+// Whether a simulation trial with this many infected and recovered people can
+// stop because we know it didn't go extinct.
+func (param Parameters) extinctionDecided(infected int, recovered int) bool {
+	cutoff := param.extinctionCutoff()
+	switch param.Extinction {
+	case StopAtCutoff:
+		return infected+recovered >= cutoff
+	case StopAtDecided:
+		return infected >= cutoff
+	default:
+		return false
+	}
+}
+
+// Whether a run has reached MaxTime by day t.
+func (param Parameters) pastMaxTime(t float64) bool {
+	return param.MaxTime > 0 && t >= param.MaxTime
 }
 
 func BetaR(R0 float64, R0c float64, meanP float64, N float64) float64 {
@@ -79,10 +307,6 @@ func BetaR(R0 float64, R0c float64, meanP float64, N float64) float64 {
 	return BetaR
 }
 
-// func CautionBetaR(infectedFraction float64, betaR float64) float64 {
-// 	return math.Max(0, (0.5-infectedFraction)*betaR)
-// }
-
 // RESULTS
 
 // Plots we want to make:
@@ -91,9 +315,13 @@ func BetaR(R0 float64, R0c float64, meanP float64, N float64) float64 {
 // 3. max Is
 // 4. dynamics over time
 type Run struct {
-	// always capture these:
+	// always capture these (with waning immunity, FinalR counts everyone
+	// who ever recovered, so people can be counted more than once):
 	FinalR float64
 	MaxI   float64
+	// Whether the run was stopped early, because it didn't go extinct (see
+	// Parameters.Extinction) or because it reached Parameters.MaxTime.
+	Truncated bool `json:",omitempty"`
 
 	// for PNASN review
 	// Duration from when infection hits 5% of the population going up to
@@ -101,9 +329,29 @@ type Run struct {
 	Duration float64
 	// Time until the infection hits its highest.
 	PeakTime float64
+	// Every period with at least 5% of the population infectious.
+	Waves []Wave `json:",omitempty"`
+	// When each of Parameters.Interventions started, or -1 if it never did.
+	InterventionStarts []float64 `json:",omitempty"`
+	// Vaccine doses given, which don't count towards FinalR.
+	Vaccinated float64 `json:",omitempty"`
+	// Infections imported from outside, see Parameters.Seeding.
+	Imported float64 `json:",omitempty"`
+	// The expected number infected by the first infected, and the initial
+	// exponential growth rate per day, of an Analytic run.
+	ReproductionNumber float64 `json:",omitempty"`
+	GrowthRate         float64 `json:",omitempty"`
+	// FinalR of each of Parameters.Communities, if there are several.
+	CommunityFinalRs []float64 `json:",omitempty"`
 
-	// these are optional:
+	// these are optional. For simulations they are only saved if
+	// Parameters.Record asks for them: Ts, Es, Is, Rs, IRisks and SRisks hold
+	// the state at the start of every step (and at the end, if the epidemic
+	// died out), RiskyInfections and CommunityInfections the new infections
+	// during every step. Es, the exposed, is only saved if there is a latent
+	// period; Is then only counts the infectious.
 	Ts                  []float64 `json:",omitempty"`
+	Es                  []float64 `json:",omitempty"`
 	Is                  []float64 `json:",omitempty"`
 	Rs                  []float64 `json:",omitempty"`
 	Rts                 []float64 `json:",omitempty"`
@@ -112,12 +360,17 @@ type Run struct {
 	SRisks              []float64 `json:",omitempty"`
 	RiskyInfections     []float64 `json:",omitempty"`
 	CommunityInfections []float64 `json:",omitempty"`
+	// Is of each of Parameters.Communities, if there are several.
+	CommunityIs [][]float64 `json:",omitempty"`
 }
 
 // One or multiple Runs with identical Parameters
 type RunSet struct {
 	Parameters Parameters
 	Runs       []Run
+	// The extinction probability of a simulation's outbreak, in theory and
+	// in its Runs, if Parameters.PredictExtinction is set.
+	Extinction *Extinction `json:",omitempty"`
 }
 
 // An R0 Series fixes a bunch of values and varies R0 systematically
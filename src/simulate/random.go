@@ -0,0 +1,37 @@
+package simulate
+
+import (
+	"math/rand/v2"
+)
+
+// Derives the seed of the i-th independent stream of seed, e.g. the i-th
+// trial of a RunSet. This is the splitmix64 finalizer, so nearby seeds and
+// indices give unrelated streams.
+func SubSeed(seed uint64, i int) uint64 {
+	z := seed + uint64(i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Adapts a math/rand/v2 source to the golang.org/x/exp/rand Source interface
+// that gonum's distributions take.
+type expSource struct {
+	pcg *rand.PCG
+}
+
+func (s expSource) Uint64() uint64 {
+	return s.pcg.Uint64()
+}
+
+func (s expSource) Seed(seed uint64) {
+	s.pcg.Seed(seed, SubSeed(seed, 0))
+}
+
+// Returns a generator seeded with seed, along with the same stream wrapped
+// for gonum. Draws from either advance both.
+func newRNG(seed uint64) (*rand.Rand, expSource) {
+	src := expSource{pcg: &rand.PCG{}}
+	src.Seed(seed)
+	return rand.New(src.pcg), src
+}
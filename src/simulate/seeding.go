@@ -0,0 +1,187 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Who the initial and imported infections go to.
+type SeedTarget string
+
+const (
+	// Everyone alike.
+	RandomSeeds SeedTarget = ""
+	// The most risk tolerant first.
+	HighestRiskSeeds SeedTarget = "highest"
+	// The least risk tolerant first.
+	LowestRiskSeeds SeedTarget = "lowest"
+	// Those nearest the Quantile of risk tolerance first.
+	QuantileSeeds SeedTarget = "quantile"
+)
+
+// Where an epidemic starts: Count initial infections, and ImportationRate
+// more a day from outside the population, going to susceptible people picked
+// by Target. With communities they all go to the first community. Without a
+// Seeding, INITIAL_INFECTED random agents (INITIAL_INFECTEDS people spread
+// over every risk bucket in the equations) are infected at the start.
+type Seeding struct {
+	Count           int
+	Target          SeedTarget `json:",omitempty"`
+	Quantile        float64    `json:",omitempty"`
+	ImportationRate float64    `json:",omitempty"`
+}
+
+func (s Seeding) Validate() error {
+	switch s.Target {
+	case RandomSeeds, HighestRiskSeeds, LowestRiskSeeds:
+	case QuantileSeeds:
+		if s.Quantile < 0 || s.Quantile > 1 {
+			return fmt.Errorf("seed quantile must be in [0, 1], got %v", s.Quantile)
+		}
+	default:
+		return fmt.Errorf("unknown seed target %q", s.Target)
+	}
+	if s.Count < 0 || s.ImportationRate < 0 {
+		return fmt.Errorf("seed count and importation rate must not be negative, got %v and %v", s.Count, s.ImportationRate)
+	}
+	if s.Count == 0 && s.ImportationRate == 0 {
+		return fmt.Errorf("seeding needs initial infections or importation")
+	}
+	return nil
+}
+
+// Whether infections keep arriving from outside, so that runs go on until
+// MaxTime even when no-one is infected.
+func (param Parameters) importing() bool {
+	return param.Seeding != nil && param.Seeding.ImportationRate > 0
+}
+
+// Orders people by where they are in the population's risk tolerance, given
+// as a quantile: the lowest go first. Only used by the targets that aren't
+// random.
+func (s Seeding) priority(quantile float64) float64 {
+	switch s.Target {
+	case HighestRiskSeeds:
+		return -quantile
+	case QuantileSeeds:
+		return math.Abs(quantile - s.Quantile)
+	default:
+		return quantile
+	}
+}
+
+// The agents who can be seeded, in the order they are: at random, or by
+// priority.
+func (p *population) seedOrder(s Seeding) []int32 {
+	order := []int32{}
+	for a := range p.risk {
+		if p.community == nil || p.community[a] == 0 {
+			order = append(order, int32(a))
+		}
+	}
+	if s.Target == RandomSeeds {
+		p.rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		return order
+	}
+	sort.SliceStable(order, func(i, j int) bool { return p.risk[order[i]] < p.risk[order[j]] })
+	priorities := make(map[int32]float64, len(order))
+	for rank, a := range order {
+		priorities[a] = s.priority((float64(rank) + 0.5) / float64(len(order)))
+	}
+	sort.SliceStable(order, func(i, j int) bool { return priorities[order[i]] < priorities[order[j]] })
+	return order
+}
+
+// Infects the next k susceptible agents of the seed order, going round it
+// again once it reaches the end, and returns the number infected.
+func (p *population) seed(k int) int {
+	infected := 0
+	for tried := 0; infected < k && tried < len(p.seeds); tried++ {
+		a := p.seeds[p.nextSeed]
+		p.nextSeed = (p.nextSeed + 1) % len(p.seeds)
+		if p.status[a] == SUSCEPTIBLE {
+			p.infect(a)
+			infected++
+			tried = 0
+		}
+	}
+	if infected > 0 {
+		stillSusceptible := p.susceptible[:0]
+		for _, a := range p.susceptible {
+			if p.status[a] == SUSCEPTIBLE {
+				stillSusceptible = append(stillSusceptible, a)
+			}
+		}
+		p.susceptible = stillSusceptible
+	}
+	return infected
+}
+
+// Infects the agents imported during a day, a Poisson number of them.
+func (p *population) importInfections(rate float64) int {
+	return p.seed(int(distuv.Poisson{Lambda: rate, Src: p.src}.Rand()))
+}
+
+// The classes who can be seeded, in the order they are, or nil if the
+// seeding is random.
+func (cls classes) seedOrder(s Seeding, population []float64) []int {
+	if s.Target == RandomSeeds {
+		return nil
+	}
+	order := []int{}
+	for c := range population {
+		if cls.community[c] == 0 {
+			order = append(order, c)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return cls.risk[order[i]] < cls.risk[order[j]] })
+	total := 0.0
+	for _, c := range order {
+		total += population[c]
+	}
+	priorities := make([]float64, len(population))
+	below := 0.0
+	for _, c := range order {
+		priorities[c] = s.priority((below + population[c]/2) / total)
+		below += population[c]
+	}
+	sort.SliceStable(order, func(i, j int) bool { return priorities[order[i]] < priorities[order[j]] })
+	return order
+}
+
+// Picks amount susceptible people where the seeding targets them, out of
+// those in S that aren't in picked already, and adds them to picked: in
+// proportion to S if the seeding is random, or along the seed order.
+func (cls classes) seed(S []float64, amount float64, picked []float64) {
+	if cls.seeds == nil {
+		available := 0.0
+		for c := range S {
+			if cls.community[c] == 0 {
+				available += S[c] - picked[c]
+			}
+		}
+		if available <= 0 {
+			return
+		}
+		amount = math.Min(amount, available)
+		for c := range S {
+			if cls.community[c] == 0 {
+				picked[c] += amount * (S[c] - picked[c]) / available
+			}
+		}
+		return
+	}
+	for _, c := range cls.seeds {
+		if amount <= 0 {
+			return
+		}
+		x := math.Min(amount, S[c]-picked[c])
+		if x > 0 {
+			picked[c] += x
+			amount -= x
+		}
+	}
+}
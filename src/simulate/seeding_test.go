@@ -0,0 +1,107 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSeedOrder(t *testing.T) {
+	param := sirParameters()
+	rng, src := newRNG(1)
+	p := newPopulation(param, rng, src)
+	for _, test := range []struct {
+		seeding Seeding
+		before  func(a, b float64) bool
+	}{
+		{Seeding{Count: 1, Target: HighestRiskSeeds}, func(a, b float64) bool { return a >= b }},
+		{Seeding{Count: 1, Target: LowestRiskSeeds}, func(a, b float64) bool { return a <= b }},
+	} {
+		order := p.seedOrder(test.seeding)
+		if len(order) != param.N {
+			t.Fatalf("%v seed order has %v agents; want %v", test.seeding.Target, len(order), param.N)
+		}
+		for i := 1; i < len(order); i++ {
+			if !test.before(p.risk[order[i-1]], p.risk[order[i]]) {
+				t.Fatalf("%v seed order isn't sorted by risk", test.seeding.Target)
+			}
+		}
+	}
+	median := p.seedOrder(Seeding{Count: 1, Target: QuantileSeeds, Quantile: 0.5})
+	below := 0
+	for a := range p.risk {
+		if p.risk[a] < p.risk[median[0]] {
+			below++
+		}
+	}
+	if below < param.N/2-1 || below > param.N/2 {
+		t.Errorf("median seed has %v agents below it; want %v", below, param.N/2)
+	}
+
+	// In the equations, the seeds fill the classes along the order.
+	seeded := param
+	seeded.Seeding = &Seeding{Count: 5, Target: HighestRiskSeeds}
+	cls, S, I, _ := initializeClasses(seeded)
+	first := cls.seeds[0]
+	for c := range cls.risk {
+		if cls.risk[c] > cls.risk[first] {
+			t.Errorf("highest risk class %v isn't seeded first", c)
+		}
+	}
+	if math.Abs(sum(I)-5) > 1e-9 || math.Abs(sum(S)+sum(I)-float64(param.N)) > 1e-6 {
+		t.Errorf("seeded %v of %v people; want 5 of %v", sum(I), sum(S)+sum(I), param.N)
+	}
+
+	for _, s := range []Seeding{
+		{},
+		{Count: -1, ImportationRate: 1},
+		{Count: 1, Target: QuantileSeeds, Quantile: 2},
+		{Count: 1, Target: "middle"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%+v passed validation", s)
+		}
+	}
+	imported := sirParameters()
+	imported.Seeding = &Seeding{ImportationRate: 1}
+	if err := imported.Validate(); err == nil {
+		t.Errorf("importation without a max time passed validation")
+	}
+}
+
+// Seeding the risk takers starts the epidemic faster than seeding the risk
+// averse, in every model.
+func TestSeedingTarget(t *testing.T) {
+	param := sirParameters()
+	param.HotspotFraction = 0.8
+	param.ComputeBetas()
+	param.Trials = 50
+	meanPeakTime := func(run func(Parameters) RunSet, target SeedTarget) float64 {
+		seeded := param
+		seeded.Seeding = &Seeding{Count: 5, Target: target}
+		total := 0.0
+		runs := run(seeded).Runs
+		for _, run := range runs {
+			total += run.PeakTime / float64(len(runs))
+		}
+		return total
+	}
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference, RunSimulation} {
+		highest, lowest := meanPeakTime(run, HighestRiskSeeds), meanPeakTime(run, LowestRiskSeeds)
+		if !(highest < lowest) {
+			t.Errorf("peak at %v seeding the highest risk; at %v seeding the lowest", highest, lowest)
+		}
+	}
+}
+
+// Importation starts an epidemic with no initial infections.
+func TestImportation(t *testing.T) {
+	param := sirParameters()
+	param.MaxTime = 100
+	param.Seeding = &Seeding{ImportationRate: 0.5}
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference, RunSimulation} {
+		got := run(param).Runs[0]
+		if got.FinalR < 0.5*float64(param.N) || got.Imported <= 0 || !got.Truncated {
+			t.Errorf("FinalR %v with %v imported infections (truncated %v)", got.FinalR, got.Imported, got.Truncated)
+		}
+	}
+}
@@ -1,20 +1,18 @@
 package simulate
 
 import (
-	//"errors"
-	randv1 "golang.org/x/exp/rand"
-	"gonum.org/v1/gonum/stat/distuv"
 	"math"
 	"math/rand/v2"
-	"time"
+	"sync"
+
+	"gonum.org/v1/gonum/stat/distuv"
 )
 
 const INITIAL_INFECTED = 1
 
-// If EXTINCTION_SHORTCUT = true, then we should end the simulation
-// if R >= EXTINCTION_CUTOFF because we know we didn't go extinct.
+// Default for Parameters.ExtinctionCutoff: once this many people have been
+// infected we know the outbreak didn't go extinct.
 const EXTINCTION_CUTOFF = 50
-const EXTINCTION_SHORTCUT = true
 
 // Infection Status enum.
 type Status int
@@ -23,157 +21,676 @@ const (
 	SUSCEPTIBLE = iota
 	INFECTED
 	RECOVERED
+	// Infected, but not infectious yet.
+	EXPOSED
+	// Immune through vaccination.
+	IMMUNE
 )
 
-// Agent class for the simulation. Each of these represents a Person
-// in the population, who can be S, I or R.
-type Person struct {
-	Status        Status
-	daysInfected  int
-	RiskTolerance float64
+// The population of a single simulation trial, stored as a struct of arrays:
+// agent a has status[a], daysInfected[a] and risk[a]. The susceptible and
+// infected agents are also kept in dense lists of indices, so that a step
+// only touches the agents that can change, and the compartment sizes are
+// just the lengths of those lists. The infected list includes the exposed
+// agents, who are counted separately.
+type population struct {
+	status       []Status
+	daysInfected []int32
+	risk         []float64
+
+	susceptible []int32
+	infected    []int32
+	exposed     int
+	recovered   int
+	// Everyone who has ever recovered, which only differs from recovered
+	// with waning immunity. The recovered are then kept in a list too, so
+	// that they can become susceptible again.
+	recoveries    int
+	recoveredList []int32
+
+	// Agents are exposed for the first latentDays of their infection and
+	// recover once they have been infected for recoveryDays. The days are
+	// drawn for each agent if the periods are split into stages, and are the
+	// same for everyone (latentLength and recoveryLength) otherwise.
+	latentLength, recoveryLength   int32
+	latentDays, recoveryDays       []int32
+	latentStages, infectiousStages stages
+
+	// Running totals of risk over the susceptible and infected agents, for
+	// recording mean risks without a scan.
+	susceptibleRisk, infectedRisk float64
+
+	// If caution is set, hotspot participation falls with the prevalence
+	// perceived this step, scaled by each agent's responsiveness (or by 1, if
+	// responsiveness is nil).
+	caution        *Caution
+	responsiveness []float64
+	perceived      float64
+
+	// How many infectious contacts each agent counts as, or nil if everyone
+	// counts as one.
+	infectiousness []float64
+
+	// Community contacts, or nil if they are well mixed.
+	network *graph
+
+	// The agents in the order they are seeded, if there is a Seeding, and
+	// the next one to try.
+	seeds    []int32
+	nextSeed int
+
+	// Whether each agent has been vaccinated, if there are vaccinations, and
+	// the number of agents the vaccine made immune.
+	vaccinated []bool
+	immune     int
+
+	// The community of each agent, and the recoveries in each community, if
+	// there are several; nil otherwise.
+	community           []int32
+	communityRecoveries []int
+
+	rng *rand.Rand
+	src expSource
+}
+
+func newPopulation(param Parameters, rng *rand.Rand, src expSource) *population {
+	p := &population{
+		status:       make([]Status, param.N),
+		daysInfected: make([]int32, param.N),
+		risk:         make([]float64, param.N),
+		susceptible:  make([]int32, param.N),
+		infected:     make([]int32, 0, param.N),
+		rng:          rng,
+		src:          src,
+
+		latentLength:   int32(param.LatentLength),
+		recoveryLength: int32(param.LatentLength + param.DiseaseLength),
+	}
+	if param.LatentStages > 0 || param.InfectiousStages > 0 {
+		p.latentDays = make([]int32, param.N)
+		p.recoveryDays = make([]int32, param.N)
+		p.latentStages = discreteStages(param.LatentLength, param.LatentStages)
+		p.infectiousStages = discreteStages(param.DiseaseLength, param.InfectiousStages)
+	}
+
+	// Each community takes the next block of agents.
+	communities := param.communities()
+	if len(param.Communities) > 0 {
+		p.community = make([]int32, param.N)
+		p.communityRecoveries = make([]int, len(communities))
+	}
+	a := 0
+	for i, community := range communities {
+		beta := distuv.Beta{
+			Alpha: community.RiskDist.A,
+			Beta:  community.RiskDist.B,
+			Src:   src,
+		}
+		for end := a + community.N; a < end; a++ {
+			p.status[a] = SUSCEPTIBLE
+			p.risk[a] = beta.Rand()
+			p.susceptible[a] = int32(a)
+			p.susceptibleRisk += p.risk[a]
+			if p.community != nil {
+				p.community[a] = int32(i)
+			}
+		}
+	}
+
+	// Drawn after the risks, so that the risks don't depend on caution.
+	if param.Caution != nil {
+		p.caution = param.Caution
+		if param.Caution.ResponsivenessVariance > 0 {
+			gamma, _ := gammaWithMean(1, param.Caution.ResponsivenessVariance, &src)
+			p.responsiveness = make([]float64, param.N)
+			for a := range p.responsiveness {
+				p.responsiveness[a] = gamma.Rand()
+			}
+		}
+	}
+	if param.AlphaDist != nil {
+		p.infectiousness = param.AlphaDist.draw(param.N, rng, src)
+	}
+	if param.WaningRate > 0 {
+		p.recoveredList = make([]int32, 0, param.N)
+	}
+	if len(param.Vaccinations) > 0 {
+		p.vaccinated = make([]bool, param.N)
+	}
+	return p
+}
+
+// Number of days agent a is exposed for.
+func (p *population) latentPeriod(a int32) int32 {
+	if p.latentDays == nil {
+		return p.latentLength
+	}
+	return p.latentDays[a]
 }
 
-func countStatus(population []*Person, status Status) int {
-	count := 0
-	for _, Person := range population {
-		if Person.Status == status {
-			count++
+// Number of days after which agent a recovers.
+func (p *population) recoveryPeriod(a int32) int32 {
+	if p.recoveryDays == nil {
+		return p.recoveryLength
+	}
+	return p.recoveryDays[a]
+}
+
+// Draws the number of days spent in a period of stages: at least a day in
+// each stage, leaving it with probability s.leave every day.
+func (p *population) drawDays(s stages) int32 {
+	if s.leave >= 1 {
+		return int32(s.n)
+	}
+	days := int32(0)
+	for j := 0; j < s.n; j++ {
+		days++
+		for p.rng.Float64() >= s.leave {
+			days++
+		}
+	}
+	return days
+}
+
+// Probability that agent a goes to hotspot h this step.
+func (p *population) participation(a int32, h Hotspot) float64 {
+	if p.community != nil && !h.drawsFrom(int(p.community[a])) {
+		return 0
+	}
+	visitation := h.visitation(p.risk[a])
+	if p.caution == nil {
+		return visitation
+	}
+	x := p.perceived
+	if p.responsiveness != nil {
+		x *= p.responsiveness[a]
+	}
+	return visitation * p.caution.response(x)
+}
+
+// Draws from Binomial(n, prob).
+func (p *population) binomial(n int, prob float64) int {
+	if n <= 0 || prob <= 0 {
+		return 0
+	}
+	if prob >= 1 {
+		return n
+	}
+	return int(distuv.Binomial{N: float64(n), P: prob, Src: p.src}.Rand())
+}
+
+// Number of infectious contacts made by the infected agents that can pass the
+// disease on: everyone but those infected during the current step.
+func (p *population) infectious() float64 {
+	count := 0.0
+	for _, a := range p.infected {
+		if p.daysInfected[a] > p.latentPeriod(a) {
+			count += p.contacts(a)
 		}
 	}
 	return count
 }
 
-func initializePopulation(population []*Person, param Parameters) {
+// Number of infectious contacts made at hotspot h this step.
+func (p *population) infectiousRiskTakers(h Hotspot) float64 {
+	count := 0.0
+	for _, a := range p.infected {
+		if p.daysInfected[a] > p.latentPeriod(a) && p.rng.Float64() < p.participation(a, h) {
+			count += p.contacts(a)
+		}
+	}
+	return count
+}
 
-	beta := distuv.Beta{
-		Alpha: param.RiskDist.A,
-		Beta:  param.RiskDist.B,
-		Src:   randv1.NewSource(uint64(time.Now().UnixNano())),
+// How many infectious contacts agent a counts as.
+func (p *population) contacts(a int32) float64 {
+	if p.infectiousness == nil {
+		return 1
 	}
+	return p.infectiousness[a]
+}
+
+// Chooses k susceptible agents uniformly at random, infects each of them with
+// probability accept(agent) (or always, if accept is nil) and returns the
+// number infected.
+//
+// Choosing Binomial(S, prob) candidates and accepting each with probability
+// r_a infects every susceptible independently with probability prob * r_a,
+// without a draw per susceptible.
+func (p *population) infectRandom(k int, accept func(a int32) bool) int {
+	sus := p.susceptible
+	n := len(sus)
 
-	for p := range population {
-		population[p] = &Person{
-			Status:        SUSCEPTIBLE,
-			daysInfected:  0,
-			RiskTolerance: beta.Rand(),
+	// Partial Fisher-Yates shuffle: sus[:k] become the candidates, and the
+	// accepted ones are kept at the front of them.
+	newInfections := 0
+	for j := 0; j < k; j++ {
+		m := j + p.rng.IntN(n-j)
+		sus[j], sus[m] = sus[m], sus[j]
+		if accept == nil || accept(sus[j]) {
+			sus[newInfections], sus[j] = sus[j], sus[newInfections]
+			newInfections++
 		}
 	}
+
+	for _, a := range sus[:newInfections] {
+		p.infect(a)
+	}
+
+	// Remove the infected agents from the front of the list by moving the
+	// agents at the back into their places.
+	remaining := n - newInfections
+	holes := newInfections
+	if remaining < holes {
+		holes = remaining
+	}
+	for x := 0; x < holes; x++ {
+		sus[x] = sus[n-holes+x]
+	}
+	p.susceptible = sus[:remaining]
+	return newInfections
 }
 
-// The probability of getting infected when making numContacts contacts, if
-// the infection rate per contact is beta.
-func infectionProbability(beta float64, numContacts float64) float64 {
-	return 1 - math.Pow(1.0-beta, numContacts)
+// Infects the susceptible agents for which chosen returns true, scanning the
+// whole susceptible list.
+func (p *population) infectWhere(chosen func(a int32) bool) int {
+	stillSusceptible := p.susceptible[:0]
+	newInfections := 0
+	for _, a := range p.susceptible {
+		if chosen(a) {
+			p.infect(a)
+			newInfections++
+		} else {
+			stillSusceptible = append(stillSusceptible, a)
+		}
+	}
+	p.susceptible = stillSusceptible
+	return newInfections
+}
+
+// Moves a susceptible agent to infected; the caller removes it from the
+// susceptible list.
+func (p *population) infect(a int32) {
+	if p.latentDays != nil {
+		p.latentDays[a] = p.drawDays(p.latentStages)
+		p.recoveryDays[a] = p.latentDays[a] + p.drawDays(p.infectiousStages)
+	}
+	p.status[a] = INFECTED
+	if p.latentPeriod(a) > 0 {
+		p.status[a] = EXPOSED
+		p.exposed++
+	}
+	p.daysInfected[a] = 0
+	p.infected = append(p.infected, a)
+	p.susceptibleRisk -= p.risk[a]
+	p.infectedRisk += p.risk[a]
+}
+
+// Risky behavioral spread: each susceptible goes to hotspot h with
+// probability equal to their visitation probability, which is their risk
+// tolerance at a single hotspot (lowered by caution, if any), and is
+// infected there with
+// probability 1 - (1 - beta)^(infectious contacts at the hotspot).
+func (p *population) spreadHotspot(h Hotspot, beta float64) int {
+	infectious := p.infectiousRiskTakers(h)
+	probability := infectionProbability(beta, infectious)
+	candidates := p.binomial(len(p.susceptible), probability)
+	return p.infectRandom(candidates, func(a int32) bool {
+		return p.rng.Float64() < p.participation(a, h)
+	})
+}
+
+// Community spread: every susceptible is infected with probability
+// 1 - (1 - beta)^(infectious contacts).
+func (p *population) spreadCommunity(beta float64, infectious float64) int {
+	probability := infectionProbability(beta, infectious)
+	return p.infectRandom(p.binomial(len(p.susceptible), probability), nil)
+}
+
+// Community spread within each community: every susceptible in community i
+// is infected with probability 1 - (1 - betaC[i])^(infectious contacts in
+// community i). Like spreadHotspot, this picks candidates with the highest of
+// these probabilities and accepts each with its own one relative to that.
+func (p *population) spreadCommunities(betaC []float64) int {
+	infectious := make([]float64, len(betaC))
+	for _, a := range p.infected {
+		if p.daysInfected[a] > p.latentPeriod(a) {
+			infectious[p.community[a]] += p.contacts(a)
+		}
+	}
+	probabilities := make([]float64, len(betaC))
+	highest := 0.0
+	for i := range probabilities {
+		probabilities[i] = infectionProbability(betaC[i], infectious[i])
+		if probabilities[i] > highest {
+			highest = probabilities[i]
+		}
+	}
+	candidates := p.binomial(len(p.susceptible), highest)
+	return p.infectRandom(candidates, func(a int32) bool {
+		return p.rng.Float64()*highest < probabilities[p.community[a]]
+	})
 }
 
-// Disease spreads within a subpopulation (possibly the whole population)
-// with contact rate * disease spread rate of beta.
-func spreadWithin(population []*Person, beta float64) {
-	var numInfected float64 = 0
-	for _, person := range population {
-		if person.Status == INFECTED && person.daysInfected > 0 {
-			numInfected += 1
+// Number of infectious agents in each community.
+func (p *population) infectiousByCommunity() []float64 {
+	counts := make([]float64, len(p.communityRecoveries))
+	for _, a := range p.infected {
+		if p.status[a] == INFECTED {
+			counts[p.community[a]]++
 		}
 	}
+	return counts
+}
 
-	var infectionProbability float64 = infectionProbability(beta, numInfected)
-	for o, other := range population {
-		if other.Status == SUSCEPTIBLE {
-			if rand.Float64() < infectionProbability {
-				population[o].Status = INFECTED
+// Infected agents recover at the end of their infectious period, and exposed
+// agents whose latent period is over become infectious.
+func (p *population) recover() {
+	stillInfected := p.infected[:0]
+	for _, a := range p.infected {
+		if p.daysInfected[a] >= p.recoveryPeriod(a) {
+			p.status[a] = RECOVERED
+			p.recovered++
+			p.recoveries++
+			if p.community != nil {
+				p.communityRecoveries[p.community[a]]++
+			}
+			p.infectedRisk -= p.risk[a]
+			if p.recoveredList != nil {
+				p.recoveredList = append(p.recoveredList, a)
+			}
+		} else {
+			p.daysInfected[a]++
+			if latent := p.latentPeriod(a); latent > 0 && p.daysInfected[a] == latent+1 {
+				p.status[a] = INFECTED
+				p.exposed--
 			}
+			stillInfected = append(stillInfected, a)
 		}
 	}
+	p.infected = stillInfected
+}
+
+// Moves every infected agent straight to recovered.
+func (p *population) recoverAll() {
+	for _, a := range p.infected {
+		p.status[a] = RECOVERED
+		if p.community != nil {
+			p.communityRecoveries[p.community[a]]++
+		}
+	}
+	p.recovered += len(p.infected)
+	p.recoveries += len(p.infected)
+	if p.recoveredList != nil {
+		p.recoveredList = append(p.recoveredList, p.infected...)
+	}
+	p.infected = p.infected[:0]
+	p.exposed = 0
+	p.infectedRisk = 0
+}
+
+// Each recovered agent loses their immunity with probability rate and becomes
+// susceptible again.
+func (p *population) wane(rate float64) {
+	list := p.recoveredList
+	n := len(list)
+	k := p.binomial(n, rate)
+
+	// Partial Fisher-Yates shuffle from the back: list[n-k:] lose their
+	// immunity.
+	for j := n - 1; j >= n-k; j-- {
+		m := p.rng.IntN(j + 1)
+		list[j], list[m] = list[m], list[j]
+		a := list[j]
+		p.status[a] = SUSCEPTIBLE
+		p.susceptible = append(p.susceptible, a)
+		p.susceptibleRisk += p.risk[a]
+	}
+	p.recoveredList = list[:n-k]
+	p.recovered -= k
+}
+
+// Mean risk tolerance of the agents in a compartment, or 0 if it is empty.
+func meanRisk(total float64, count int) float64 {
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
+
+// The probability of getting infected when making numContacts contacts, if
+// the infection rate per contact is beta.
+func infectionProbability(beta float64, numContacts float64) float64 {
+	return 1 - math.Pow(1.0-beta, numContacts)
 }
 
 func RunSimulation(param Parameters) RunSet {
+	return RunSimulationWorkers(param, 1)
+}
+
+// Like RunSimulation, but spreads the trials over the given number of
+// goroutines. Runs are stored in trial order regardless of which worker
+// finishes first.
+func RunSimulationWorkers(param Parameters, workers int) RunSet {
 
 	// Saves the parameters used for this simulation along with the top level
 	// results we care about.
 	runSet := RunSet{
 		Parameters: param,
-		Runs:       make([]Run, 0),
+		Runs:       make([]Run, param.Trials),
 	}
 
-	// Conduct param.Trials discrete trials of the epidemic
-	for i := 0; i < param.Trials; i++ {
-		//fmt.Printf("\r%v/%v", i, param.Trials)
+	// Conduct param.Trials discrete trials of the epidemic. Each trial draws
+	// from its own generator, so the order they run in doesn't matter.
+	// An edge list is the same for every trial, so it is only read once.
+	var edgeList *graph
+	if param.Network != nil && param.Network.Kind == EdgeList {
+		edgeList = buildNetwork(*param.Network, param.N, nil)
+	}
+	parallelFor(param.Trials, workers, func(i int) {
+		runSet.Runs[i] = runTrial(param, i, edgeList)
+	})
+	runSet.Extinction = simulatedExtinction(runSet)
+	return runSet
+}
 
-		// Set up the population for the trial.
-		var population []*Person = make([]*Person, param.N)
-		Is := []float64{}
-		initializePopulation(population, param)
+// Builds the network, panicking if it can't since Validate has checked it
+// and read any edge list.
+func buildNetwork(network Network, n int, rng *rand.Rand) *graph {
+	g, err := network.build(n, rng)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
 
-		// Infect initial people:
-		for infect := 0; infect < INITIAL_INFECTED; infect++ {
-			population[infect].Status = INFECTED
+// Calls f(i) for i in [0, n) using up to workers goroutines at a time.
+func parallelFor(n int, workers int, f func(i int)) {
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
 		}
+		return
+	}
 
-		// Set up timing measurements
-		var time int = 0
-		var peakTime float64 = 0
-
-		// Time loop of the trial
-		// The simulation continues until no-one is infected.
-		maxInfected := 0
-		for infected := 1; infected > 0; infected = countStatus(population, INFECTED) {
-			// measure peak number of infections & timing
-			if infected > maxInfected {
-				maxInfected = infected
-				peakTime = float64(time)
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
 			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
 
-			Is = append(Is, float64(infected))
-
-			// Shortcut out if we only care about probability of extinction.
-			if EXTINCTION_SHORTCUT {
-				recovered := countStatus(population, RECOVERED)
-				if recovered+infected >= EXTINCTION_CUTOFF {
-					for p := range population {
-						if population[p].Status == INFECTED {
-							population[p].Status = RECOVERED
-						}
-					}
-					break
-				}
-			}
+// Runs a single trial of the epidemic. Every random draw comes from a
+// generator seeded with SubSeed(param.Seed, trial), so RunTrial(param, i)
+// reproduces Runs[i] of RunSimulation(param).
+func RunTrial(param Parameters, trial int) Run {
+	return runTrial(param, trial, nil)
+}
 
-			// risky behavioral spread
-			riskTakers := make([]*Person, 0)
-			for _, Person := range population {
-				if rand.Float64() < Person.RiskTolerance {
-					riskTakers = append(riskTakers, Person)
-				}
-			}
+// Like RunTrial, with the network already built if it comes from an edge
+// list.
+func runTrial(param Parameters, trial int, edgeList *graph) Run {
+	rng, src := newRNG(SubSeed(param.Seed, trial))
 
-			spreadWithin(riskTakers, param.BetaR)
+	// Set up the population for the trial, and infect the initial people.
+	// Risk tolerances are drawn independently, so the first agents are as
+	// good as any. The network is drawn after everything else, so that it
+	// doesn't change the rest.
+	population := newPopulation(param, rng, src)
+	// Scales BetaC to the rate along each edge.
+	edgeScale := 0.0
+	if param.Network != nil {
+		population.network = edgeList
+		if population.network == nil {
+			population.network = buildNetwork(*param.Network, param.N, rng)
+		}
+		if degree := population.network.meanDegree(); degree > 0 {
+			edgeScale = float64(param.N) / degree
+		}
+	}
+	if param.Seeding == nil {
+		population.infectWhere(func(a int32) bool { return a < INITIAL_INFECTED })
+	} else {
+		population.seeds = population.seedOrder(*param.Seeding)
+		population.seed(param.Seeding.Count)
+	}
+	campaigns := population.campaigns(param.Vaccinations)
+	Is := []float64{}
 
-			// community spread
-			spreadWithin(population, param.BetaC)
+	// Set up timing measurements
+	var time int = 0
+	var peakTime float64 = 0
 
-			// recovery
-			for p := range population {
-				if population[p].Status == INFECTED {
-					if population[p].daysInfected >= param.DiseaseLength {
-						population[p].Status = RECOVERED
-					} else {
-						population[p].daysInfected++
-					}
-				}
+	// Optionally save the trajectory. The state is recorded at the start of
+	// every step and once more when the epidemic dies out, and the infections
+	// during every step that was run.
+	run := Run{}
+	recordState := func() {
+		if param.Record == RecordNone {
+			return
+		}
+		run.Ts = append(run.Ts, float64(time))
+		if param.LatentLength > 0 {
+			run.Es = append(run.Es, float64(population.exposed))
+		}
+		run.Is = append(run.Is, float64(len(population.infected)-population.exposed))
+		run.Rs = append(run.Rs, float64(population.recovered))
+		if population.community != nil {
+			if run.CommunityIs == nil {
+				run.CommunityIs = make([][]float64, len(population.communityRecoveries))
+			}
+			for i, count := range population.infectiousByCommunity() {
+				run.CommunityIs[i] = append(run.CommunityIs[i], count)
 			}
-			time += 1
 		}
+		if param.Record == RecordAll {
+			run.IRisks = append(run.IRisks, meanRisk(population.infectedRisk, len(population.infected)))
+			run.SRisks = append(run.SRisks, meanRisk(population.susceptibleRisk, len(population.susceptible)))
+		}
+	}
 
-		// The epidemic has run its course, so now we save the things we want
-		// to save.
-		runSet.Runs = append(runSet.Runs, Run{
-			FinalR:   float64(countStatus(population, RECOVERED)),
-			MaxI:     float64(maxInfected),
-			Duration: computeOutbreakDuration(Is, param),
-			PeakTime: peakTime,
-			// Is:       Is,
-		})
+	schedule := newInterventionSchedule(param.Interventions)
+	communities, hotspots := param.communities(), param.hotspots()
 
+	// Time loop of the trial
+	// The simulation continues until no-one is infected, exposed or
+	// infectious.
+	maxInfected := 0
+	for infected := len(population.infected); infected > 0 || param.importing(); infected = len(population.infected) {
+		// measure peak number of infections & timing
+		sick := infected - population.exposed
+		if sick > maxInfected {
+			maxInfected = sick
+			peakTime = float64(time)
+		}
+
+		Is = append(Is, float64(sick))
+		recordState()
+
+		// Shortcut out if we only care about probability of extinction.
+		if param.extinctionDecided(infected, population.recoveries) {
+			population.recoverAll()
+			run.Truncated = true
+			break
+		}
+		if param.pastMaxTime(float64(time)) {
+			run.Truncated = true
+			break
+		}
+		if campaigns != nil {
+			run.Vaccinated += float64(population.vaccinate(campaigns, time))
+		}
+		if param.importing() {
+			run.Imported += float64(population.importInfections(param.Seeding.ImportationRate))
+		}
+
+		// Nobody infected during this step is infectious yet, so count the
+		// infectious agents before any spread. Exposed agents aren't
+		// infectious either.
+		infectious := population.infectious()
+		if param.Caution != nil {
+			population.perceived = param.Caution.prevalence(
+				float64(sick), float64(population.recoveries), float64(param.N))
+		}
+		betaC, betaR := schedule.rates(float64(time), float64(sick)/float64(param.N), communities, hotspots)
+
+		// risky behavioral spread, at each hotspot in turn
+		riskyInfections := 0
+		for k, h := range hotspots {
+			riskyInfections += population.spreadHotspot(h, betaR[k])
+		}
+
+		// community spread
+		var communityInfections int
+		if population.network != nil {
+			communityInfections = population.spreadNetwork(betaC[0] * edgeScale)
+		} else if population.community != nil {
+			communityInfections = population.spreadCommunities(betaC)
+		} else {
+			communityInfections = population.spreadCommunity(betaC[0], infectious)
+		}
+
+		if param.Record == RecordAll {
+			run.RiskyInfections = append(run.RiskyInfections, float64(riskyInfections))
+			run.CommunityInfections = append(run.CommunityInfections, float64(communityInfections))
+		}
+
+		// recovery, and loss of immunity
+		population.recover()
+		if param.WaningRate > 0 {
+			population.wane(param.WaningRate)
+		}
+		time += 1
 	}
-	return runSet
+
+	if !run.Truncated {
+		recordState()
+	}
+
+	// The epidemic has run its course, so now we save the things we want
+	// to save.
+	run.FinalR = float64(population.recoveries)
+	run.MaxI = float64(maxInfected)
+	run.Duration = computeOutbreakDuration(Is, param, 1)
+	run.PeakTime = peakTime
+	run.Waves = computeWaves(Is, param, 1)
+	run.InterventionStarts = schedule.startTimes()
+	if population.community != nil {
+		run.CommunityFinalRs = make([]float64, len(communities))
+		for i, count := range population.communityRecoveries {
+			run.CommunityFinalRs[i] = float64(count)
+		}
+	}
+	return run
 }
@@ -1,7 +1,13 @@
 package simulate
 
 import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
 	"testing"
+
+	"gonum.org/v1/gonum/stat/distuv"
 )
 
 func TestInfectionProbability(t *testing.T) {
@@ -22,3 +28,328 @@ func TestInfectionProbability(t *testing.T) {
 
 	}
 }
+
+func TestParallelFor(t *testing.T) {
+	for _, workers := range []int{1, 3, 8} {
+		counts := make([]int, 20)
+		parallelFor(len(counts), workers, func(i int) {
+			counts[i]++
+		})
+		for i, count := range counts {
+			if count != 1 {
+				t.Fatalf("parallelFor(workers=%v) called f(%v) %v times; want 1", workers, i, count)
+			}
+		}
+	}
+}
+
+func TestRunSimulationWorkers(t *testing.T) {
+	param := defaultParameters
+	param.Trials = 20
+	param.BetaC = 2.0 / N
+	runSet := RunSimulationWorkers(param, 4)
+	if len(runSet.Runs) != param.Trials {
+		t.Fatalf("got %v runs; want %v", len(runSet.Runs), param.Trials)
+	}
+	for _, run := range runSet.Runs {
+		if run.FinalR < INITIAL_INFECTED {
+			t.Fatalf("FinalR %v is below the initial infecteds", run.FinalR)
+		}
+	}
+}
+
+func TestRunSimulationSeed(t *testing.T) {
+	param := defaultParameters
+	param.Trials = 20
+	param.BetaC = 1.5 / N
+	param.BetaR = 1.5 / N
+	param.Seed = 42
+
+	want := RunSimulation(param)
+	if got := RunSimulation(param); !reflect.DeepEqual(got, want) {
+		t.Fatalf("two runs with the same seed differ")
+	}
+	if got := RunSimulationWorkers(param, 4); !reflect.DeepEqual(got, want) {
+		t.Fatalf("running trials in parallel changed the results")
+	}
+	// Each trial can be replayed on its own.
+	for _, trial := range []int{0, 7, 19} {
+		if got := RunTrial(param, trial); !reflect.DeepEqual(got, want.Runs[trial]) {
+			t.Fatalf("RunTrial(param, %v) = %+v; want %+v", trial, got, want.Runs[trial])
+		}
+	}
+
+	param.Seed = 43
+	if got := RunSimulation(param); reflect.DeepEqual(got.Runs, want.Runs) {
+		t.Fatalf("runs with different seeds are identical")
+	}
+}
+
+func TestSubSeed(t *testing.T) {
+	seen := map[uint64]bool{}
+	for _, seed := range []uint64{0, 1, 2} {
+		for i := 0; i < 100; i++ {
+			sub := SubSeed(seed, i)
+			if seen[sub] {
+				t.Fatalf("SubSeed(%v, %v) = %v repeats an earlier sub-seed", seed, i, sub)
+			}
+			seen[sub] = true
+		}
+	}
+}
+
+func TestRunTrialRecord(t *testing.T) {
+	param := defaultParameters
+	if run := RunTrial(param, 0); run.Ts != nil || run.Is != nil {
+		t.Fatalf("trajectory saved without Parameters.Record")
+	}
+
+	// Without any spread the index case is infected for two steps.
+	param.Record = RecordAll
+	run := RunTrial(param, 0)
+	for _, test := range []struct {
+		name string
+		got  []float64
+		want []float64
+	}{
+		{"Ts", run.Ts, []float64{0, 1, 2}},
+		{"Is", run.Is, []float64{1, 1, 0}},
+		{"Rs", run.Rs, []float64{0, 0, 1}},
+		{"RiskyInfections", run.RiskyInfections, []float64{0, 0}},
+		{"CommunityInfections", run.CommunityInfections, []float64{0, 0}},
+		{"SRisks", run.SRisks[2:], []float64{run.SRisks[0]}},
+		{"IRisks", run.IRisks[2:], []float64{0}},
+	} {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Fatalf("%v = %v; want %v", test.name, test.got, test.want)
+		}
+	}
+
+	// A population too small to hit the extinction cutoff: every infection
+	// is recorded.
+	param.N = 40
+	param.BetaC = 1.0 / 40
+	param.BetaR = 2.0 / 40
+	for trial := 0; trial < 10; trial++ {
+		run := RunTrial(param, trial)
+		if len(run.Is) != len(run.Ts) || len(run.RiskyInfections) != len(run.Ts)-1 {
+			t.Fatalf("trajectory lengths don't line up: %v states, %v steps",
+				len(run.Ts), len(run.RiskyInfections))
+		}
+		infections := 0.0
+		for s := range run.RiskyInfections {
+			infections += run.RiskyInfections[s] + run.CommunityInfections[s]
+		}
+		if infections+INITIAL_INFECTED != run.FinalR || run.Rs[len(run.Rs)-1] != run.FinalR {
+			t.Fatalf("recorded %v infections for a final size of %v", infections, run.FinalR)
+		}
+	}
+}
+
+func TestExtinctionMode(t *testing.T) {
+	param := defaultParameters
+	param.BetaC = 3.0 / N
+	param.Record = RecordCompartments
+
+	for _, test := range []struct {
+		mode ExtinctionMode
+		// whether a big outbreak is truncated, and the smallest final size
+		// it can then have.
+		truncated bool
+		minFinalR float64
+	}{
+		{StopAtCutoff, true, EXTINCTION_CUTOFF},
+		{StopAtDecided, true, EXTINCTION_CUTOFF},
+		{FullRun, false, 500},
+	} {
+		param.Extinction = test.mode
+		for trial := 0; trial < 20; trial++ {
+			run := RunTrial(param, trial)
+			if run.FinalR < 10 {
+				// went extinct on its own
+				if run.Truncated {
+					t.Fatalf("mode %q: a run that went extinct was truncated", test.mode)
+				}
+				continue
+			}
+			if run.Truncated != test.truncated || run.FinalR < test.minFinalR {
+				t.Fatalf("mode %q: Truncated = %v, FinalR = %v; want %v, at least %v",
+					test.mode, run.Truncated, run.FinalR, test.truncated, test.minFinalR)
+			}
+			if test.mode == StopAtDecided && run.Is[len(run.Is)-1] < EXTINCTION_CUTOFF {
+				t.Fatalf("mode %q: stopped with only %v infected", test.mode, run.Is[len(run.Is)-1])
+			}
+		}
+	}
+
+	param.Extinction = FullRun
+	param.ExtinctionCutoff = 5
+	if err := param.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	param.Extinction = "sometimes"
+	if err := param.Validate(); err == nil {
+		t.Fatalf("expected an error for an unknown extinction mode")
+	}
+}
+
+func TestInfectRandom(t *testing.T) {
+	param := defaultParameters
+	param.N = 100
+	rng, src := newRNG(1)
+	population := newPopulation(param, rng, src)
+
+	for _, k := range []int{0, 10, 45, 30, 15} {
+		before := len(population.susceptible)
+		// Accept every other candidate agent.
+		newInfections := population.infectRandom(k, func(a int32) bool { return a%2 == 0 })
+		if len(population.susceptible) != before-newInfections || newInfections > k {
+			t.Fatalf("infectRandom(%v) infected %v, susceptibles went from %v to %v",
+				k, newInfections, before, len(population.susceptible))
+		}
+		seen := map[int32]bool{}
+		for _, a := range population.susceptible {
+			if seen[a] || population.status[a] != SUSCEPTIBLE {
+				t.Fatalf("agent %v is listed as susceptible twice or has status %v", a, population.status[a])
+			}
+			seen[a] = true
+		}
+		for _, a := range population.infected {
+			if seen[a] || population.status[a] != INFECTED || a%2 != 0 {
+				t.Fatalf("agent %v should not be infected", a)
+			}
+			seen[a] = true
+		}
+		if len(seen) != param.N {
+			t.Fatalf("%v agents are listed; want %v", len(seen), param.N)
+		}
+	}
+}
+
+// The original engine, which scans the whole population of *Person several
+// times a step. It is kept to check the new engine against and to benchmark
+// it.
+type referencePerson struct {
+	Status        Status
+	daysInfected  int
+	RiskTolerance float64
+}
+
+func referenceCount(population []*referencePerson, status Status) int {
+	count := 0
+	for _, person := range population {
+		if person.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func referenceSpreadWithin(population []*referencePerson, beta float64, rng *rand.Rand) {
+	var numInfected float64 = 0
+	for _, person := range population {
+		if person.Status == INFECTED && person.daysInfected > 0 {
+			numInfected += 1
+		}
+	}
+	var infectionProbability float64 = infectionProbability(beta, numInfected)
+	for _, other := range population {
+		if other.Status == SUSCEPTIBLE && rng.Float64() < infectionProbability {
+			other.Status = INFECTED
+		}
+	}
+}
+
+func referenceTrial(param Parameters, trial int) Run {
+	rng, src := newRNG(SubSeed(param.Seed, trial))
+	beta := distuv.Beta{Alpha: param.RiskDist.A, Beta: param.RiskDist.B, Src: src}
+	population := make([]*referencePerson, param.N)
+	for p := range population {
+		population[p] = &referencePerson{Status: SUSCEPTIBLE, RiskTolerance: beta.Rand()}
+	}
+	population[0].Status = INFECTED
+
+	maxInfected := 0
+	for infected := 1; infected > 0; infected = referenceCount(population, INFECTED) {
+		if infected > maxInfected {
+			maxInfected = infected
+		}
+		riskTakers := make([]*referencePerson, 0)
+		for _, person := range population {
+			if rng.Float64() < person.RiskTolerance {
+				riskTakers = append(riskTakers, person)
+			}
+		}
+		referenceSpreadWithin(riskTakers, param.BetaR, rng)
+		referenceSpreadWithin(population, param.BetaC, rng)
+		for _, person := range population {
+			if person.Status == INFECTED {
+				if person.daysInfected >= param.DiseaseLength {
+					person.Status = RECOVERED
+				} else {
+					person.daysInfected++
+				}
+			}
+		}
+	}
+	return Run{FinalR: float64(referenceCount(population, RECOVERED)), MaxI: float64(maxInfected)}
+}
+
+func benchmarkParameters(n int) Parameters {
+	param := Parameters{
+		N:               n,
+		DiseaseLength:   1,
+		R0:              2,
+		HotspotFraction: 0.5,
+		RiskMean:        0.25,
+		RiskVariance:    MediumVar,
+		Trials:          1,
+		Extinction:      FullRun,
+	}
+	param.ComputeBetas()
+	return param
+}
+
+// The new engine draws differently, so compare the distributions of final
+// sizes and peaks rather than individual runs.
+func TestRunTrialMatchesReference(t *testing.T) {
+	param := benchmarkParameters(500)
+	const trials = 400
+	for _, statistic := range []struct {
+		name  string
+		value func(Run) float64
+	}{
+		{"FinalR", func(run Run) float64 { return run.FinalR }},
+		{"MaxI", func(run Run) float64 { return run.MaxI }},
+	} {
+		var mean, reference, variance float64
+		for trial := 0; trial < trials; trial++ {
+			got := statistic.value(RunTrial(param, trial))
+			want := statistic.value(referenceTrial(param, trial))
+			mean += got / trials
+			reference += want / trials
+			variance += want * want / trials
+		}
+		standardError := math.Sqrt((variance - reference*reference) * 2 / trials)
+		if math.Abs(mean-reference) > 4*standardError {
+			t.Fatalf("mean %v = %v; the reference engine gives %v (standard error %v)",
+				statistic.name, mean, reference, standardError)
+		}
+	}
+}
+
+func BenchmarkRunTrial(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		param := benchmarkParameters(n)
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				RunTrial(param, i)
+			}
+		})
+		b.Run(fmt.Sprintf("reference/N=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				referenceTrial(param, i)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package simulate
+
+import (
+	"fmt"
+)
+
+// The latent or infectious period split into a chain of stages. Everyone in
+// a stage moves on to the next at the same rate (per unit time in the
+// differential equation, per day in the discrete models), so a period of n
+// stages is Erlang (or negative binomially) distributed.
+type stages struct {
+	n     int
+	leave float64
+}
+
+// A period of mean length split into n stages for the differential
+// equation; a single stage if n is 0. A period of length 0 has no stages.
+func continuousStages(length int, n int) stages {
+	if length == 0 {
+		return stages{}
+	}
+	if n == 0 {
+		n = 1
+	}
+	return stages{n: n, leave: float64(n) / float64(length)}
+}
+
+// A period of mean length days split into n stages for the discrete models.
+// If n is 0 the period lasts exactly length days, i.e. length stages that
+// are always left after a day.
+func discreteStages(length int, n int) stages {
+	if n == 0 {
+		n = length
+	}
+	if n == 0 {
+		return stages{}
+	}
+	return stages{n: n, leave: float64(n) / float64(length)}
+}
+
+// Moves people along a chain of stages over a step of length dt:
+// chain[j][c] holds class c in stage j. Whoever leaves stage j gets into
+// stage j+1, and those leaving the last stage are added to out. in holds the
+// people entering the first stage; if the chain has no stages they go
+// straight to out. Departures are worked out before anyone arrives.
+func advance(chain [][]float64, in []float64, out []float64, s stages, dt float64) {
+	if len(chain) == 0 {
+		for c := range in {
+			out[c] += in[c]
+		}
+		return
+	}
+	fraction := s.leave * dt
+	for c := range in {
+		arriving := in[c]
+		for j := range chain {
+			leaving := chain[j][c] * s.leave * dt
+			if fraction == 1 {
+				// Everyone moves on, so only the new arrivals are left.
+				chain[j][c] = arriving
+			} else {
+				chain[j][c] += arriving
+				chain[j][c] -= leaving
+			}
+			arriving = leaving
+		}
+		out[c] += arriving
+	}
+}
+
+// The rate of change of a chain of stages in the differential equation, added
+// to dchain: in holds the rate people enter the first stage at, and the rate
+// they leave the last at is added to out, as in advance.
+func flow(chain [][]float64, in []float64, dchain [][]float64, out []float64, s stages) {
+	for c := range in {
+		arriving := in[c]
+		for j := range chain {
+			leaving := chain[j][c] * s.leave
+			dchain[j][c] += arriving - leaving
+			arriving = leaving
+		}
+		out[c] += arriving
+	}
+}
+
+// Moves people along a chain of stages over a step of length dt like
+// advance, but implicitly: each stage is left at the rate its size at the end
+// of the step gives, so that none can go negative.
+func settle(chain [][]float64, in []float64, out []float64, s stages, dt float64) {
+	for c := range in {
+		arriving := in[c]
+		for j := range chain {
+			before := chain[j][c] + arriving
+			chain[j][c] = before / (1 + s.leave*dt)
+			arriving = before - chain[j][c]
+		}
+		out[c] += arriving
+	}
+}
+
+// Total number of people in a chain of stages.
+func chainSum(chain [][]float64) float64 {
+	total := 0.0
+	for _, stage := range chain {
+		total += sum(stage)
+	}
+	return total
+}
+
+// A chain of n stages over the given number of classes, all empty.
+func newChain(n int, classes int) [][]float64 {
+	chain := make([][]float64, n)
+	for j := range chain {
+		chain[j] = make([]float64, classes)
+	}
+	return chain
+}
+
+// Checks the latent and infectious stage settings against the period
+// lengths: in the discrete models a stage must last at least a day.
+func (param Parameters) validateStages() error {
+	if param.LatentLength < 0 || param.LatentStages < 0 || param.InfectiousStages < 0 {
+		return fmt.Errorf("latent length and stage counts must not be negative")
+	}
+	if param.LatentStages > 0 && param.LatentLength == 0 {
+		return fmt.Errorf("latent stages need a latent length")
+	}
+	if param.RunType == Difference && param.DiseaseLength < 1 {
+		return fmt.Errorf("%s runs need a disease length of at least a day, got %d", param.RunType, param.DiseaseLength)
+	}
+	if param.RunType == DifEq || param.DiseaseLength == 0 {
+		return nil
+	}
+	if param.LatentStages > param.LatentLength || param.InfectiousStages > param.DiseaseLength {
+		return fmt.Errorf("%s runs need at most one stage per day, got %d latent stages over %d days and %d infectious stages over %d days",
+			param.RunType, param.LatentStages, param.LatentLength, param.InfectiousStages, param.DiseaseLength)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package simulate
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAdvance(t *testing.T) {
+	// Fixed periods shift everyone along a stage a day.
+	chain := [][]float64{{1}, {2}, {3}}
+	out := []float64{10}
+	advance(chain, []float64{4}, out, discreteStages(3, 0), 1)
+	if want := [][]float64{{4}, {1}, {2}}; !reflect.DeepEqual(chain, want) || out[0] != 13 {
+		t.Fatalf("advanced to %v and %v; want %v and 13", chain, out, want)
+	}
+
+	// Stages keep everyone until they leave the last one.
+	chain = [][]float64{{1}, {2}}
+	out = []float64{0}
+	advance(chain, []float64{1}, out, continuousStages(4, 2), 0.1)
+	if total := chainSum(chain) + out[0]; math.Abs(total-4) > tolerance || math.Abs(out[0]-0.1) > tolerance {
+		t.Fatalf("advanced to %v and %v", chain, out)
+	}
+
+	// Without stages people go straight through.
+	out = []float64{1}
+	advance(nil, []float64{2}, out, discreteStages(0, 0), 1)
+	if out[0] != 3 {
+		t.Fatalf("passed %v through an empty chain; want 3", out[0])
+	}
+}
+
+func TestDrawDays(t *testing.T) {
+	rng, src := newRNG(2)
+	p := &population{rng: rng, src: src}
+	if days := p.drawDays(discreteStages(4, 0)); days != 4 {
+		t.Fatalf("fixed period of %v days; want 4", days)
+	}
+	mean := 0.0
+	for i := 0; i < 100000; i++ {
+		mean += float64(p.drawDays(discreteStages(6, 2))) / 100000
+	}
+	if math.Abs(mean-6) > 0.05 {
+		t.Fatalf("mean staged period %v; want 6", mean)
+	}
+}
+
+func TestValidateStages(t *testing.T) {
+	for _, param := range []Parameters{
+		{RunType: Simulation, DiseaseLength: 2, InfectiousStages: 3},
+		{RunType: Difference, DiseaseLength: 1, LatentLength: 2, LatentStages: 3},
+		{RunType: Difference, DiseaseLength: 0},
+		{RunType: DifEq, DiseaseLength: 1, LatentStages: 1},
+		{RunType: DifEq, DiseaseLength: 1, LatentLength: -1},
+	} {
+		if err := param.Validate(); err == nil {
+			t.Errorf("%+v passed validation", param)
+		}
+	}
+	param := Parameters{RunType: DifEq, DiseaseLength: 1, InfectiousStages: 4}
+	if err := param.Validate(); err != nil {
+		t.Errorf("%+v failed validation: %v", param, err)
+	}
+}
+
+// A latent period delays the epidemic without changing its final size, in
+// the differential and difference equations.
+func TestLatentPeriod(t *testing.T) {
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference} {
+		sir := sirParameters()
+		seir := sir
+		seir.LatentLength = 2
+		without, with := run(sir).Runs[0], run(seir).Runs[0]
+		if math.Abs(with.FinalR-without.FinalR) > 0.001*without.FinalR {
+			t.Errorf("FinalR %v with a latent period; %v without", with.FinalR, without.FinalR)
+		}
+		if len(with.Es) != len(with.Is) || len(without.Es) != 0 {
+			t.Errorf("recorded %v exposed and %v infectious", len(with.Es), len(with.Is))
+		}
+		if len(with.Is) <= len(without.Is) {
+			t.Errorf("epidemic lasted %v steps with a latent period; %v without", len(with.Is), len(without.Is))
+		}
+	}
+
+	// Erlang stages with the same mean leave the final size alone too.
+	sir := sirParameters()
+	sir.DiseaseLength = 4
+	sir.ComputeBetas()
+	staged := sir
+	staged.InfectiousStages = 4
+	without, with := RunDifEq(sir).Runs[0], RunDifEq(staged).Runs[0]
+	if math.Abs(with.FinalR-without.FinalR) > 0.001*without.FinalR {
+		t.Errorf("FinalR %v with infectious stages; %v without", with.FinalR, without.FinalR)
+	}
+}
+
+// In the simulation the index case is exposed for the latent period, then
+// infectious for the disease length.
+func TestSimulationLatentPeriod(t *testing.T) {
+	param := sirParameters()
+	param.R0 = 0
+	param.ComputeBetas()
+	param.LatentLength = 2
+	param.DiseaseLength = 3
+	run := RunTrial(param, 0)
+	if want := []float64{1, 1, 1, 0, 0, 0, 0}; !reflect.DeepEqual(run.Es, want) {
+		t.Errorf("exposed %v; want %v", run.Es, want)
+	}
+	if want := []float64{0, 0, 0, 1, 1, 1, 0}; !reflect.DeepEqual(run.Is, want) {
+		t.Errorf("infectious %v; want %v", run.Is, want)
+	}
+
+	// Drawn periods take the same time on average.
+	param.LatentStages, param.InfectiousStages = 2, 3
+	param.Trials = 2000
+	mean := 0.0
+	for _, run := range RunSimulation(param).Runs {
+		mean += float64(len(run.Ts)-1) / float64(param.Trials)
+	}
+	if math.Abs(mean-6) > 0.2 {
+		t.Errorf("infections lasted %v steps on average; want 6", mean)
+	}
+}
@@ -0,0 +1,247 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Who a vaccination campaign goes to first.
+type VaccineTarget string
+
+const (
+	// Everyone alike.
+	UniformTarget VaccineTarget = ""
+	// The most risk tolerant first.
+	HighestRisk VaccineTarget = "highest"
+	// Everyone alike whose risk tolerance is between the Lower and Upper
+	// quantiles of the population's.
+	QuantileTarget VaccineTarget = "quantile"
+)
+
+// A vaccination campaign, or pre-existing immunity if it starts at 0 and
+// takes no time. Coverage * N doses go to susceptible people who haven't
+// been vaccinated yet, at an even rate over Duration days from Start (all at
+// once if Duration is 0), until no-one in the target group is left. Each dose
+// makes its recipient immune for good with probability Efficacy, and does
+// nothing otherwise.
+type Vaccination struct {
+	Coverage     float64
+	Target       VaccineTarget `json:",omitempty"`
+	Lower, Upper float64       `json:",omitempty"`
+	Efficacy     float64
+	Start        float64 `json:",omitempty"`
+	Duration     float64 `json:",omitempty"`
+}
+
+func (v Vaccination) Validate() error {
+	switch v.Target {
+	case UniformTarget, HighestRisk:
+	case QuantileTarget:
+		if v.Lower < 0 || v.Upper > 1 || v.Lower >= v.Upper {
+			return fmt.Errorf("vaccination quantiles must satisfy 0 <= lower < upper <= 1, got %v and %v", v.Lower, v.Upper)
+		}
+	default:
+		return fmt.Errorf("unknown vaccination target %q", v.Target)
+	}
+	if v.Coverage < 0 || v.Coverage > 1 {
+		return fmt.Errorf("vaccination coverage must be in [0, 1], got %v", v.Coverage)
+	}
+	if v.Efficacy <= 0 || v.Efficacy > 1 {
+		return fmt.Errorf("vaccine efficacy must be in (0, 1], got %v", v.Efficacy)
+	}
+	if v.Start < 0 || v.Duration < 0 {
+		return fmt.Errorf("vaccination start and duration must not be negative, got %v and %v", v.Start, v.Duration)
+	}
+	return nil
+}
+
+// The doses due by the end of a step of length dt starting at t, out of a
+// population of n.
+func (v Vaccination) dosesBy(t float64, dt float64, n int) float64 {
+	doses := v.Coverage * float64(n)
+	if t+dt <= v.Start {
+		return 0
+	}
+	if v.Duration == 0 || t+dt >= v.Start+v.Duration {
+		return doses
+	}
+	return doses * (t + dt - v.Start) / v.Duration
+}
+
+// Whether the person at position rank of n, in order of increasing risk
+// tolerance, is in the target group.
+func (v Vaccination) targets(rank int, n int) bool {
+	if v.Target != QuantileTarget {
+		return true
+	}
+	quantile := (float64(rank) + 0.5) / float64(n)
+	return quantile >= v.Lower && quantile < v.Upper
+}
+
+// A vaccination campaign in the simulation: the agents in the order they are
+// offered a dose, and how far along it the campaign is.
+type agentCampaign struct {
+	vaccination Vaccination
+	order       []int32
+	next        int
+	given       int
+}
+
+// Orders the agents of every campaign: everyone in the target group at
+// random, or the most risk tolerant first.
+func (p *population) campaigns(vaccinations []Vaccination) []*agentCampaign {
+	if len(vaccinations) == 0 {
+		return nil
+	}
+	n := len(p.risk)
+	byRisk := make([]int32, n)
+	for a := range byRisk {
+		byRisk[a] = int32(a)
+	}
+	sort.SliceStable(byRisk, func(i, j int) bool { return p.risk[byRisk[i]] < p.risk[byRisk[j]] })
+
+	campaigns := make([]*agentCampaign, len(vaccinations))
+	for i, v := range vaccinations {
+		order := make([]int32, 0, n)
+		if v.Target == HighestRisk {
+			for rank := n - 1; rank >= 0; rank-- {
+				order = append(order, byRisk[rank])
+			}
+		} else {
+			for rank, a := range byRisk {
+				if v.targets(rank, n) {
+					order = append(order, a)
+				}
+			}
+			p.rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		}
+		campaigns[i] = &agentCampaign{vaccination: v, order: order}
+	}
+	return campaigns
+}
+
+// Gives the doses due by the end of the step starting at day t, and returns
+// how many were given.
+func (p *population) vaccinate(campaigns []*agentCampaign, t int) int {
+	given, immunized := 0, 0
+	for _, c := range campaigns {
+		due := int(math.Floor(c.vaccination.dosesBy(float64(t), 1, len(p.risk)) + 1e-9))
+		for ; c.given < due && c.next < len(c.order); c.next++ {
+			a := c.order[c.next]
+			if p.status[a] != SUSCEPTIBLE || p.vaccinated[a] {
+				continue
+			}
+			p.vaccinated[a] = true
+			c.given++
+			given++
+			if p.rng.Float64() < c.vaccination.Efficacy {
+				p.status[a] = IMMUNE
+				p.immune++
+				p.susceptibleRisk -= p.risk[a]
+				immunized++
+			}
+		}
+	}
+	if immunized > 0 {
+		stillSusceptible := p.susceptible[:0]
+		for _, a := range p.susceptible {
+			if p.status[a] == SUSCEPTIBLE {
+				stillSusceptible = append(stillSusceptible, a)
+			}
+		}
+		p.susceptible = stillSusceptible
+	}
+	return given
+}
+
+// A vaccination campaign in the differential and difference equations.
+// eligible[c] is the share of class c in the target group, and order the
+// classes in the order they are offered doses if the most risk tolerant go
+// first.
+type classCampaign struct {
+	vaccination Vaccination
+	eligible    []float64
+	order       []int
+	given       float64
+}
+
+// Sets up the campaigns of a mean-field model whose classes start with the
+// given populations.
+func (cls classes) campaigns(vaccinations []Vaccination, population []float64) []*classCampaign {
+	if len(vaccinations) == 0 {
+		return nil
+	}
+	byRisk := make([]int, len(population))
+	for c := range byRisk {
+		byRisk[c] = c
+	}
+	sort.SliceStable(byRisk, func(i, j int) bool { return cls.risk[byRisk[i]] < cls.risk[byRisk[j]] })
+	total := sum(population)
+
+	campaigns := make([]*classCampaign, len(vaccinations))
+	for i, v := range vaccinations {
+		campaign := &classCampaign{vaccination: v, eligible: make([]float64, len(population))}
+		below := 0.0
+		for _, c := range byRisk {
+			// The share of the class between the quantiles.
+			lower, upper := below/total, (below+population[c])/total
+			below += population[c]
+			campaign.eligible[c] = 1
+			if v.Target == QuantileTarget && upper > lower {
+				overlap := math.Min(upper, v.Upper) - math.Max(lower, v.Lower)
+				campaign.eligible[c] = math.Max(0, overlap) / (upper - lower)
+			}
+		}
+		if v.Target == HighestRisk {
+			for j := len(byRisk) - 1; j >= 0; j-- {
+				campaign.order = append(campaign.order, byRisk[j])
+			}
+		}
+		campaigns[i] = campaign
+	}
+	return campaigns
+}
+
+// Gives the doses due by the end of the step of length dt starting at t to
+// the unvaccinated susceptible, unvaccinated[c] of the S[c] in class c, and
+// moves those it makes immune out of S. Returns the doses given. The target
+// group is taken to be spread evenly over a class.
+func vaccinateClasses(campaigns []*classCampaign, t float64, dt float64, n int, S []float64, unvaccinated []float64) float64 {
+	given := 0.0
+	dose := func(c int, doses float64, efficacy float64) {
+		unvaccinated[c] -= doses
+		S[c] -= doses * efficacy
+		given += doses
+	}
+	for _, campaign := range campaigns {
+		v := campaign.vaccination
+		due := v.dosesBy(t, dt, n) - campaign.given
+		if due <= 0 {
+			continue
+		}
+		if v.Target == HighestRisk {
+			for _, c := range campaign.order {
+				doses := math.Min(due, unvaccinated[c])
+				if doses > 0 {
+					dose(c, doses, v.Efficacy)
+					campaign.given += doses
+					due -= doses
+				}
+			}
+			continue
+		}
+		available := weightedSum(unvaccinated, campaign.eligible)
+		if available <= 0 {
+			continue
+		}
+		doses := math.Min(due, available)
+		for c, eligible := range campaign.eligible {
+			if share := doses * eligible * unvaccinated[c] / available; share > 0 {
+				dose(c, share, v.Efficacy)
+			}
+		}
+		campaign.given += doses
+	}
+	return given
+}
@@ -0,0 +1,99 @@
+package simulate
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDosesBy(t *testing.T) {
+	rollout := Vaccination{Coverage: 0.5, Start: 10, Duration: 20}
+	for _, test := range []struct {
+		t, dt, want float64
+	}{
+		{0, 1, 0},
+		{9, 1, 0},
+		{10, 1, 2.5},
+		{19, 1, 25},
+		{29, 1, 50},
+		{40, 1, 50},
+		{10, 0.01, 0.025},
+	} {
+		if got := rollout.dosesBy(test.t, test.dt, 100); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("dosesBy(%v, %v) = %v; want %v", test.t, test.dt, got, test.want)
+		}
+	}
+	if got := (Vaccination{Coverage: 0.5}).dosesBy(0, 1, 100); got != 50 {
+		t.Errorf("pre-existing immunity gives %v doses; want 50", got)
+	}
+
+	for _, v := range []Vaccination{
+		{Coverage: 0.5},
+		{Coverage: 1.5, Efficacy: 1},
+		{Coverage: 0.5, Efficacy: 1, Target: QuantileTarget, Lower: 0.5, Upper: 0.5},
+		{Coverage: 0.5, Efficacy: 1, Target: "lowest"},
+	} {
+		if err := v.Validate(); err == nil {
+			t.Errorf("%+v passed validation", v)
+		}
+	}
+}
+
+// The quantile target covers that share of every model's population.
+func TestVaccinationTargets(t *testing.T) {
+	param := sirParameters()
+	cls, S, initial, _ := initializeClasses(param)
+	population := initialPopulation(S, initial)
+	quantile := Vaccination{Coverage: 1, Efficacy: 1, Target: QuantileTarget, Lower: 0.8, Upper: 0.9}
+	campaign := cls.campaigns([]Vaccination{quantile}, population)[0]
+	if got := weightedSum(population, campaign.eligible); math.Abs(got-0.1*float64(param.N)) > 1e-6 {
+		t.Errorf("quantile target covers %v people; want %v", got, 0.1*float64(param.N))
+	}
+
+	rng, src := newRNG(1)
+	p := newPopulation(param, rng, src)
+	order := p.campaigns([]Vaccination{quantile})[0].order
+	if len(order) != param.N/10 {
+		t.Fatalf("quantile target covers %v agents; want %v", len(order), param.N/10)
+	}
+	highest := p.campaigns([]Vaccination{{Coverage: 1, Efficacy: 1, Target: HighestRisk}})[0].order
+	for i := 1; i < len(highest); i++ {
+		if p.risk[highest[i]] > p.risk[highest[i-1]] {
+			t.Fatalf("highest risk target isn't in decreasing order of risk")
+		}
+	}
+}
+
+// Vaccinating the risk takers protects the others better than vaccinating at
+// random, and giving the doses over time protects less than having them in
+// place at the start.
+func TestVaccination(t *testing.T) {
+	param := sirParameters()
+	param.N = 2000
+	param.HotspotFraction = 0.8
+	param.ComputeBetas()
+	param.Trials = 100
+	finalR := func(run func(Parameters) RunSet, vaccination Vaccination) (float64, float64) {
+		vaccinated := param
+		vaccinated.Vaccinations = []Vaccination{vaccination}
+		total, doses := 0.0, 0.0
+		runs := run(vaccinated).Runs
+		for _, run := range runs {
+			total += run.FinalR / float64(len(runs))
+			doses += run.Vaccinated / float64(len(runs))
+		}
+		return total, doses
+	}
+	for _, run := range []func(Parameters) RunSet{RunDifEq, runDifference, RunSimulation} {
+		uniform, doses := finalR(run, Vaccination{Coverage: 0.2, Efficacy: 1})
+		if math.Abs(doses-400) > 1 {
+			t.Errorf("gave %v doses; want 400", doses)
+		}
+		targeted, _ := finalR(run, Vaccination{Coverage: 0.2, Efficacy: 1, Target: HighestRisk})
+		rollout, _ := finalR(run, Vaccination{Coverage: 0.2, Efficacy: 1, Target: HighestRisk, Duration: 30})
+		failing, _ := finalR(run, Vaccination{Coverage: 0.2, Efficacy: 0.5, Target: HighestRisk})
+		if !(targeted < uniform && targeted < rollout && targeted < failing) {
+			t.Errorf("FinalR %v vaccinating the highest risk first; %v at random, %v over time and %v with half the efficacy",
+				targeted, uniform, rollout, failing)
+		}
+	}
+}
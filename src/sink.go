@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/brendanwallace/hotspot/simulate"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Output formats for a sweep.
+const (
+	// A single nested JSON document, written once the sweep is done.
+	FORMAT_JSON = "json"
+	// One row per run, written as each point finishes.
+	FORMAT_CSV     = "csv"
+	FORMAT_JSONL   = "jsonl"
+	FORMAT_PARQUET = "parquet"
+)
+
+// Parquet rows are buffered until they take up about this many bytes, and
+// then written as a row group.
+const PARQUET_ROW_GROUP_SIZE = 16 * 1024 * 1024
+
+// Receives the results of a sweep, one RunSet at a time in sweep order.
+type sink interface {
+	Write(runSet simulate.RunSet) error
+	// Called once after the last RunSet, or after an error.
+	Close() error
+}
+
+// Creates a sink writing to fileName plus the extension of the format. Every
+// format starts with the metadata and the experiment.
+func newSink(format string, fileName string, meta metadata, experiment simulate.Experiment, allSeries []simulate.R0Series) (sink, error) {
+	h := header{Metadata: meta, Experiment: experiment}
+	switch format {
+	case FORMAT_JSON:
+		return newJSONSink(fileName+".json", meta, experiment, allSeries), nil
+	case FORMAT_CSV:
+		return newCSVSink(fileName+".csv", h)
+	case FORMAT_JSONL:
+		return newJSONLSink(fileName+".jsonl", h)
+	case FORMAT_PARQUET:
+		return newParquetSink(fileName+".parquet", h)
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// What the flat formats record about a sweep besides its rows.
+type header struct {
+	Metadata   metadata
+	Experiment simulate.Experiment
+}
+
+// The nested JSON output: the metadata followed by the experiment and its
+// results.
+type jsonOutput struct {
+	Metadata metadata
+	simulate.ExperimentResults
+}
+
+// One row of the flat output formats: a single run along with the
+// parameters that produced it. The columns match the ones the figures code
+// derives from the nested JSON.
+type row struct {
+	RunType         string  `parquet:"name=RunType, type=BYTE_ARRAY, convertedtype=UTF8"`
+	N               int64   `parquet:"name=N, type=INT64"`
+	DiseaseLength   int64   `parquet:"name=DiseaseLength, type=INT64"`
+	R0              float64 `parquet:"name=R0, type=DOUBLE"`
+	HotspotFraction float64 `parquet:"name=HotspotFraction, type=DOUBLE"`
+	RiskMean        float64 `parquet:"name=RiskMean, type=DOUBLE"`
+	RiskVariance    string  `parquet:"name=RiskVariance, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Trial           int64   `parquet:"name=Trial, type=INT64"`
+	FinalR          float64 `parquet:"name=FinalR, type=DOUBLE"`
+	MaxI            float64 `parquet:"name=MaxI, type=DOUBLE"`
+	PeakTime        float64 `parquet:"name=PeakTime, type=DOUBLE"`
+	Duration        float64 `parquet:"name=Duration, type=DOUBLE"`
+	Truncated       bool    `parquet:"name=Truncated, type=BOOLEAN"`
+	// The run's RunSet.Extinction, empty without one.
+	ExtinctionProbability *float64 `parquet:"name=ExtinctionProbability, type=DOUBLE, repetitiontype=OPTIONAL"`
+	OutbreakFraction      *float64 `parquet:"name=OutbreakFraction, type=DOUBLE, repetitiontype=OPTIONAL"`
+}
+
+var rowColumns = []string{
+	"RunType", "N", "DiseaseLength", "R0", "HotspotFraction", "RiskMean", "RiskVariance",
+	"Trial", "FinalR", "MaxI", "PeakTime", "Duration", "Truncated",
+	"ExtinctionProbability", "OutbreakFraction",
+}
+
+func (r row) values() []string {
+	float := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	optional := func(f *float64) string {
+		if f == nil {
+			return ""
+		}
+		return float(*f)
+	}
+	return []string{
+		r.RunType, strconv.FormatInt(r.N, 10), strconv.FormatInt(r.DiseaseLength, 10),
+		float(r.R0), float(r.HotspotFraction), float(r.RiskMean), r.RiskVariance,
+		strconv.FormatInt(r.Trial, 10), float(r.FinalR), float(r.MaxI), float(r.PeakTime),
+		float(r.Duration), strconv.FormatBool(r.Truncated),
+		optional(r.ExtinctionProbability), optional(r.OutbreakFraction),
+	}
+}
+
+func rows(runSet simulate.RunSet) []row {
+	param := runSet.Parameters
+	rows := make([]row, len(runSet.Runs))
+	for trial, run := range runSet.Runs {
+		rows[trial] = row{
+			RunType:         string(param.RunType),
+			N:               int64(param.N),
+			DiseaseLength:   int64(param.DiseaseLength),
+			R0:              param.R0,
+			HotspotFraction: param.HotspotFraction,
+			RiskMean:        param.RiskMean,
+			RiskVariance:    string(param.RiskVariance),
+			Trial:           int64(trial),
+			FinalR:          run.FinalR,
+			MaxI:            run.MaxI,
+			PeakTime:        run.PeakTime,
+			Duration:        run.Duration,
+			Truncated:       run.Truncated,
+		}
+		if runSet.Extinction != nil {
+			rows[trial].ExtinctionProbability = &runSet.Extinction.Probability
+			rows[trial].OutbreakFraction = &runSet.Extinction.OutbreakFraction
+		}
+	}
+	return rows
+}
+
+// Fills in the expanded series and writes them all when closed.
+type jsonSink struct {
+	fileName string
+	output   jsonOutput
+	// where the next RunSet goes
+	series, runSet int
+}
+
+func newJSONSink(fileName string, meta metadata, experiment simulate.Experiment, allSeries []simulate.R0Series) *jsonSink {
+	return &jsonSink{
+		fileName: fileName,
+		output: jsonOutput{
+			Metadata:          meta,
+			ExperimentResults: simulate.ExperimentResults{Experiment: experiment, Series: allSeries},
+		},
+	}
+}
+
+func (s *jsonSink) Write(runSet simulate.RunSet) error {
+	series := s.output.Series
+	for s.runSet == len(series[s.series].RunSets) {
+		s.series, s.runSet = s.series+1, 0
+	}
+	series[s.series].RunSets[s.runSet] = runSet
+	s.runSet++
+	return nil
+}
+
+func (s *jsonSink) Close() error {
+	s.output.Metadata.finish()
+	file, err := json.MarshalIndent(s.output, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.fileName, file, DATA_FILE_PERMISSIONS)
+}
+
+type csvSink struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// The header goes on a comment line, starting with #, above the column names.
+func newCSVSink(fileName string, h header) (*csvSink, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DATA_FILE_PERMISSIONS)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(h)
+	if err == nil {
+		_, err = fmt.Fprintf(file, "# %s\n", data)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &csvSink{file: file, writer: csv.NewWriter(file)}
+	if err := s.writer.Write(rowColumns); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *csvSink) Write(runSet simulate.RunSet) error {
+	for _, r := range rows(runSet) {
+		if err := s.writer.Write(r.values()); err != nil {
+			return err
+		}
+	}
+	// Flush after every point so that a crash only loses the running ones.
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+type jsonlSink struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// The header is the first line; every other line is a row.
+func newJSONLSink(fileName string, h header) (*jsonlSink, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DATA_FILE_PERMISSIONS)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	s := &jsonlSink{file: file, buffer: buffer, encoder: json.NewEncoder(buffer)}
+	if err := s.encoder.Encode(h); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *jsonlSink) Write(runSet simulate.RunSet) error {
+	for _, r := range rows(runSet) {
+		if err := s.encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return s.buffer.Flush()
+}
+
+func (s *jsonlSink) Close() error {
+	if err := s.buffer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// Writes a row group every PARQUET_ROW_GROUP_SIZE bytes of rows, and the
+// rest when closed. Parquet keeps its schema and index in a
+// footer, so the file is only readable once the sink is closed. The header
+// is saved in the footer's key-value metadata, one JSON value per field.
+type parquetSink struct {
+	file   *os.File
+	writer *writer.ParquetWriter
+	header header
+}
+
+func newParquetSink(fileName string, h header) (*parquetSink, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, DATA_FILE_PERMISSIONS)
+	if err != nil {
+		return nil, err
+	}
+	w, err := writer.NewParquetWriterFromWriter(file, new(row), 1)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+	w.RowGroupSize = PARQUET_ROW_GROUP_SIZE
+	return &parquetSink{file: file, writer: w, header: h}, nil
+}
+
+func (s *parquetSink) Write(runSet simulate.RunSet) error {
+	for _, r := range rows(runSet) {
+		if err := s.writer.Write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *parquetSink) Close() error {
+	s.header.Metadata.finish()
+	for _, field := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"Metadata", s.header.Metadata},
+		{"Experiment", s.header.Experiment},
+	} {
+		data, err := json.Marshal(field.value)
+		if err != nil {
+			s.file.Close()
+			return err
+		}
+		value := string(data)
+		s.writer.Footer.KeyValueMetadata = append(s.writer.Footer.KeyValueMetadata,
+			&parquet.KeyValue{Key: field.key, Value: &value})
+	}
+	if err := s.writer.WriteStop(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brendanwallace/hotspot/simulate"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func testSeries() []simulate.R0Series {
+	param := simulate.Parameters{
+		RunType:         simulate.Simulation,
+		N:               1000,
+		DiseaseLength:   1,
+		HotspotFraction: 0.5,
+		RiskMean:        0.25,
+		RiskVariance:    simulate.HighVar,
+		Trials:          2,
+	}
+	series := simulate.R0Series{RunType: simulate.Simulation, HotspotFraction: 0.5}
+	for _, R0 := range []float64{1, 2} {
+		param.R0 = R0
+		series.RunSets = append(series.RunSets, simulate.RunSet{
+			Parameters: param,
+			Runs: []simulate.Run{
+				{FinalR: 10 * R0, MaxI: 3, PeakTime: 2, Duration: 0},
+				{FinalR: 50, MaxI: 20, PeakTime: 5, Duration: 1, Truncated: true},
+			},
+		})
+	}
+	// Only the second point predicted its extinction.
+	series.RunSets[1].Parameters.PredictExtinction = true
+	series.RunSets[1].Extinction = &simulate.Extinction{Probability: 0.4, OutbreakFraction: 0.5}
+	return []simulate.R0Series{series}
+}
+
+func TestSinks(t *testing.T) {
+	allSeries := testSeries()
+	want := []row{}
+	for _, runSet := range allSeries[0].RunSets {
+		want = append(want, rows(runSet)...)
+	}
+	if len(want) != 4 || want[1].Trial != 1 || want[2].R0 != 2 || want[3].RiskVariance != "high" ||
+		want[1].OutbreakFraction != nil || *want[3].OutbreakFraction != 0.5 {
+		t.Fatalf("unexpected rows %+v", want)
+	}
+
+	dir := t.TempDir()
+	for _, format := range []string{FORMAT_JSON, FORMAT_CSV, FORMAT_JSONL, FORMAT_PARQUET} {
+		// The JSON sink fills in a copy of the expanded series.
+		skeleton := testSeries()
+		for r := range skeleton[0].RunSets {
+			skeleton[0].RunSets[r].Runs = nil
+		}
+		fileName := filepath.Join(dir, "results")
+		out, err := newSink(format, fileName, newMetadata(3), simulate.Experiment{Name: "test"}, skeleton)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		for _, runSet := range allSeries[0].RunSets {
+			if err := out.Write(runSet); err != nil {
+				t.Fatalf("%v: %v", format, err)
+			}
+		}
+		if err := out.Close(); err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+
+		h := readHeader(t, format, fileName+"."+format)
+		if h.Metadata.OutputVersion != OUTPUT_VERSION || h.Metadata.Seed != 3 || h.Metadata.GoVersion == "" || h.Metadata.Constants.BUCKETS != simulate.BUCKETS ||
+			h.Experiment.Name != "test" {
+			t.Fatalf("%v: read back header %+v", format, h)
+		}
+		finished := format == FORMAT_JSON || format == FORMAT_PARQUET
+		if (h.Metadata.Finished != nil) != finished {
+			t.Fatalf("%v: finish time %v", format, h.Metadata.Finished)
+		}
+
+		got := readRows(t, format, fileName+"."+format)
+		if format == FORMAT_JSON {
+			var results simulate.ExperimentResults
+			data, _ := ioutil.ReadFile(fileName + ".json")
+			if err := json.Unmarshal(data, &results); err != nil {
+				t.Fatal(err)
+			}
+			if results.Experiment.Name != "test" || !reflect.DeepEqual(results.Series, allSeries) {
+				t.Fatalf("%v: read back %+v", format, results)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%v: read back %+v; want %+v", format, got, want)
+		}
+	}
+}
+
+// Reads the rows back from a flat output file.
+func readRows(t *testing.T, format string, fileName string) []row {
+	got := []row{}
+	switch format {
+	case FORMAT_CSV:
+		file, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		csvReader := csv.NewReader(file)
+		csvReader.Comment = '#'
+		records, err := csvReader.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[0], rowColumns) {
+			t.Fatalf("csv header %v; want %v", records[0], rowColumns)
+		}
+		for _, record := range records[1:] {
+			// Round trip through JSON to parse the values.
+			fields := map[string]json.RawMessage{}
+			for c, column := range rowColumns {
+				value := record[c]
+				if column == "RunType" || column == "RiskVariance" {
+					value = `"` + value + `"`
+				}
+				if value == "" {
+					value = "null"
+				}
+				fields[column] = json.RawMessage(value)
+			}
+			data, _ := json.Marshal(fields)
+			var r row
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		}
+	case FORMAT_JSONL:
+		file, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Scan()
+		for scanner.Scan() {
+			var r row
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, r)
+		}
+	case FORMAT_PARQUET:
+		file, err := local.NewLocalFileReader(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		pr, err := reader.NewParquetReader(file, new(row), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The points are small enough to share a row group.
+		if groups := len(pr.Footer.RowGroups); groups != 1 {
+			t.Fatalf("%d row groups; want 1", groups)
+		}
+		got = make([]row, pr.GetNumRows())
+		if err := pr.Read(&got); err != nil {
+			t.Fatal(err)
+		}
+		pr.ReadStop()
+	}
+	return got
+}
+
+// Reads the metadata and experiment back from an output file.
+func readHeader(t *testing.T, format string, fileName string) header {
+	var h header
+	var data []byte
+	switch format {
+	case FORMAT_JSON:
+		data, _ = ioutil.ReadFile(fileName)
+	case FORMAT_CSV, FORMAT_JSONL:
+		file, err := os.Open(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		line, _ := bufio.NewReader(file).ReadBytes('\n')
+		data = bytes.TrimPrefix(line, []byte("# "))
+	case FORMAT_PARQUET:
+		file, err := local.NewLocalFileReader(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		pr, err := reader.NewParquetReader(file, nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := []string{}
+		for _, kv := range pr.Footer.KeyValueMetadata {
+			fields = append(fields, fmt.Sprintf("%q:%s", kv.Key, *kv.Value))
+		}
+		pr.ReadStop()
+		data = []byte("{" + strings.Join(fields, ",") + "}")
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		t.Fatalf("%v: %v", format, err)
+	}
+	return h
+}